@@ -4,6 +4,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,17 +21,17 @@ type crtbLifecycle struct {
 
 func (c *crtbLifecycle) Create(obj *v3.ClusterRoleTemplateBinding) (*v3.ClusterRoleTemplateBinding, error) {
 	err := c.syncCRTB(obj)
-	return obj, err
+	return obj, c.m.recordFailure(obj, reasonSyncFailed, err)
 }
 
 func (c *crtbLifecycle) Updated(obj *v3.ClusterRoleTemplateBinding) (*v3.ClusterRoleTemplateBinding, error) {
 	err := c.syncCRTB(obj)
-	return obj, err
+	return obj, c.m.recordFailure(obj, reasonSyncFailed, err)
 }
 
 func (c *crtbLifecycle) Remove(obj *v3.ClusterRoleTemplateBinding) (*v3.ClusterRoleTemplateBinding, error) {
 	err := c.ensureCRTBDelete(obj)
-	return obj, err
+	return obj, c.m.recordFailure(obj, reasonRemoveFailed, err)
 }
 
 func (c *crtbLifecycle) syncCRTB(binding *v3.ClusterRoleTemplateBinding) error {
@@ -104,6 +105,7 @@ func (c *crtbLifecycle) ensureClusterBinding(roles map[string]*v3.RoleTemplate,
 		if err != nil {
 			return err
 		}
+		c.m.events.Management.Eventf(binding, v1.EventTypeNormal, reasonBound, "Bound user %s to cluster role %s", binding.UserName, rb.RoleRef.Name)
 	}
 
 	for name := range rbsToDelete {
@@ -2,18 +2,20 @@ package authz
 
 import (
 	"fmt"
-	"reflect"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
 	typescorev1 "github.com/rancher/types/apis/core/v1"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 	typesrbacv1 "github.com/rancher/types/apis/rbac.authorization.k8s.io/v1"
 	"github.com/rancher/types/config"
 	"k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -24,9 +26,14 @@ const (
 	prtbByProjectUserIndex = "authz.cluster.cattle.io/prtb-by-project-user"
 	nsByProjectIndex       = "authz.cluster.cattle.io/ns-by-project"
 	crByNSIndex            = "authz.cluster.cattle.io/cr-by-ns"
+
+	reasonBound        = "Bound"
+	reasonRoleSynced   = "RoleSynced"
+	reasonSyncFailed   = "SyncFailed"
+	reasonRemoveFailed = "RemoveFailed"
 )
 
-func Register(workload *config.ClusterContext) {
+func Register(workload *config.ClusterContext, events *utils.Recorders) {
 	// Add cache informer to project role template bindings
 	informer := workload.Management.Management.ProjectRoleTemplateBindings("").Controller().Informer()
 	indexers := map[string]cache.IndexFunc{
@@ -61,6 +68,7 @@ func Register(workload *config.ClusterContext) {
 		nsLister:      workload.Core.Namespaces("").Controller().Lister(),
 		clusterLister: workload.Management.Management.Clusters("").Controller().Lister(),
 		clusterName:   workload.ClusterName,
+		events:        events,
 	}
 	workload.Management.Management.Projects("").AddClusterScopedLifecycle("project-namespace-auth", workload.ClusterName, newProjectLifecycle(r))
 	workload.Management.Management.ProjectRoleTemplateBindings("").AddClusterScopedLifecycle("cluster-prtb-sync", workload.ClusterName, newPRTBLifecycle(r))
@@ -81,6 +89,15 @@ type manager struct {
 	nsLister      typescorev1.NamespaceLister
 	clusterLister v3.ClusterLister
 	clusterName   string
+	events        *utils.Recorders
+}
+
+// recordFailure records the error of a reconcile as a warning on the management object, and returns it
+func (m *manager) recordFailure(obj runtime.Object, reason string, err error) error {
+	if err != nil {
+		m.events.Management.Eventf(obj, v1.EventTypeWarning, reason, "%v", err)
+	}
+	return err
 }
 
 func (m *manager) ensureRoles(rts map[string]*v3.RoleTemplate) error {
@@ -91,19 +108,21 @@ func (m *manager) ensureRoles(rts map[string]*v3.RoleTemplate) error {
 		}
 
 		if role, err := m.crLister.Get("", rt.Name); err == nil && role != nil {
-			if reflect.DeepEqual(role.Rules, rt.Rules) {
+			// nil and empty rules are the same, the role would be updated and an event recorded on every sync otherwise
+			if equality.Semantic.DeepEqual(role.Rules, rt.Rules) {
 				continue
 			}
 			role = role.DeepCopy()
 			role.Rules = rt.Rules
-			_, err := roleCli.Update(role)
+			updated, err := roleCli.Update(role)
 			if err != nil {
 				return errors.Wrapf(err, "couldn't update role %v", rt.Name)
 			}
+			m.events.Cluster.Eventf(updated, v1.EventTypeNormal, reasonRoleSynced, "Updated rules from role template %s", rt.Name)
 			continue
 		}
 
-		_, err := roleCli.Create(&rbacv1.ClusterRole{
+		created, err := roleCli.Create(&rbacv1.ClusterRole{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: rt.Name,
 			},
@@ -112,6 +131,7 @@ func (m *manager) ensureRoles(rts map[string]*v3.RoleTemplate) error {
 		if err != nil {
 			return errors.Wrapf(err, "couldn't create role %v", rt.Name)
 		}
+		m.events.Cluster.Eventf(created, v1.EventTypeNormal, reasonRoleSynced, "Created from role template %s", rt.Name)
 	}
 
 	return nil
@@ -173,6 +193,7 @@ func (m *manager) ensureBindings(ns string, roles map[string]*v3.RoleTemplate, b
 		if err != nil {
 			return err
 		}
+		m.events.Management.Eventf(binding, v1.EventTypeNormal, reasonBound, "Bound user %s to role %s in namespace %s", binding.UserName, rb.RoleRef.Name, ns)
 	}
 
 	for name := range rbsToDelete {
@@ -35,12 +35,12 @@ type nsLifecycle struct {
 
 func (n *nsLifecycle) Create(obj *v1.Namespace) (*v1.Namespace, error) {
 	err := n.syncNS(obj)
-	return obj, err
+	return obj, n.recordFailure(obj, err)
 }
 
 func (n *nsLifecycle) Updated(obj *v1.Namespace) (*v1.Namespace, error) {
 	err := n.syncNS(obj)
-	return obj, err
+	return obj, n.recordFailure(obj, err)
 }
 
 func (n *nsLifecycle) Remove(obj *v1.Namespace) (*v1.Namespace, error) {
@@ -48,6 +48,14 @@ func (n *nsLifecycle) Remove(obj *v1.Namespace) (*v1.Namespace, error) {
 	return obj, err
 }
 
+// recordFailure records the error of a namespace reconcile as a warning on the namespace, and returns it
+func (n *nsLifecycle) recordFailure(obj *v1.Namespace, err error) error {
+	if err != nil {
+		n.m.events.Cluster.Eventf(obj, v1.EventTypeWarning, reasonSyncFailed, "%v", err)
+	}
+	return err
+}
+
 func (n *nsLifecycle) syncNS(obj *v1.Namespace) error {
 	if err := n.ensurePRTBAddToNamespace(obj); err != nil {
 		return err
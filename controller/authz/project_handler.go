@@ -29,13 +29,13 @@ func (p *pLifecycle) Create(project *v3.Project) (*v3.Project, error) {
 
 		err = p.m.createProjectNSRole(roleName, verb, "")
 		if err != nil {
-			return project, err
+			return project, p.m.recordFailure(project, reasonSyncFailed, err)
 		}
 
 	}
 
 	err := p.ensureDefaultNamespaceAssigned(project)
-	return project, err
+	return project, p.m.recordFailure(project, reasonSyncFailed, err)
 }
 
 func (p *pLifecycle) Updated(project *v3.Project) (*v3.Project, error) {
@@ -43,19 +43,26 @@ func (p *pLifecycle) Updated(project *v3.Project) (*v3.Project, error) {
 }
 
 func (p *pLifecycle) Remove(project *v3.Project) (*v3.Project, error) {
+	if err := p.removeProject(project); err != nil {
+		return project, p.m.recordFailure(project, reasonRemoveFailed, err)
+	}
+	return nil, nil
+}
+
+func (p *pLifecycle) removeProject(project *v3.Project) error {
 	for _, suffix := range projectNSVerbToSuffix {
 		roleName := fmt.Sprintf(projectNSGetClusterRoleNameFmt, project.Name, suffix)
 
 		err := p.m.workload.RBAC.ClusterRoles("").Delete(roleName, &v1.DeleteOptions{})
 		if err != nil && !apierrors.IsNotFound(err) {
-			return project, err
+			return err
 		}
 	}
 
 	projectID := project.Namespace + ":" + project.Name
 	namespaces, err := p.m.nsIndexer.ByIndex(nsByProjectIndex, projectID)
 	if err != nil {
-		return project, err
+		return err
 	}
 
 	for _, o := range namespaces {
@@ -63,7 +70,7 @@ func (p *pLifecycle) Remove(project *v3.Project) (*v3.Project, error) {
 		if _, ok := namespace.Annotations["field.cattle.io/creatorId"]; ok {
 			err := p.m.workload.Core.Namespaces("").Delete(namespace.Name, &v1.DeleteOptions{})
 			if err != nil && !apierrors.IsNotFound(err) {
-				return project, err
+				return err
 			}
 		} else {
 			namespace = namespace.DeepCopy()
@@ -71,13 +78,13 @@ func (p *pLifecycle) Remove(project *v3.Project) (*v3.Project, error) {
 				delete(namespace.Annotations, projectIDAnnotation)
 				_, err := p.m.workload.Core.Namespaces("").Update(namespace)
 				if err != nil {
-					return project, err
+					return err
 				}
 			}
 		}
 	}
 
-	return nil, nil
+	return nil
 }
 
 func (p *pLifecycle) ensureDefaultNamespaceAssigned(project *v3.Project) error {
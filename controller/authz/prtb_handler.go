@@ -27,17 +27,17 @@ type prtbLifecycle struct {
 
 func (p *prtbLifecycle) Create(obj *v3.ProjectRoleTemplateBinding) (*v3.ProjectRoleTemplateBinding, error) {
 	err := p.syncPRTB(obj)
-	return obj, err
+	return obj, p.m.recordFailure(obj, reasonSyncFailed, err)
 }
 
 func (p *prtbLifecycle) Updated(obj *v3.ProjectRoleTemplateBinding) (*v3.ProjectRoleTemplateBinding, error) {
 	err := p.syncPRTB(obj)
-	return obj, err
+	return obj, p.m.recordFailure(obj, reasonSyncFailed, err)
 }
 
 func (p *prtbLifecycle) Remove(obj *v3.ProjectRoleTemplateBinding) (*v3.ProjectRoleTemplateBinding, error) {
 	err := p.ensurePRTBDelete(obj)
-	return obj, err
+	return obj, p.m.recordFailure(obj, reasonRemoveFailed, err)
 }
 
 func (p *prtbLifecycle) syncPRTB(binding *v3.ProjectRoleTemplateBinding) error {
@@ -0,0 +1,282 @@
+package configmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/norman/clientbase"
+	"github.com/rancher/norman/controller"
+	"github.com/rancher/norman/lifecycle"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/rancher/types/config"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Controller listens for config map CUD in management API
+// and propagates the changes to all corresponding namespaces in cluster API
+
+// NamespaceController listens to cluster namespace events,
+// reads config maps from the management namespace of corresponding project,
+// and creates the config maps in the cluster namespace
+
+const (
+	// sourceProjectLabel records the project a config map was copied from
+	sourceProjectLabel = "configmap.cluster.cattle.io/source-project"
+	// sourceUIDLabel records the UID of the config map a copy was made from
+	sourceUIDLabel = "configmap.cluster.cattle.io/source-uid"
+	// contentHashAnnotation records the hash of the source content a copy was written with
+	contentHashAnnotation = "configmap.cluster.cattle.io/content-hash"
+
+	reasonPropagated        = "ConfigMapPropagated"
+	reasonPropagationFailed = "ConfigMapPropagationFailed"
+	reasonRemoved           = "ConfigMapRemoved"
+	reasonConflict          = "ConfigMapConflict"
+)
+
+type Controller struct {
+	copier                    *projectcopy.Copier
+	clusterConfigMaps         *configMapLister
+	clusterNamespaceLister    v1.NamespaceLister
+	managementNamespaceLister v1.NamespaceLister
+	projectLister             v3.ProjectLister
+	clusterName               string
+	events                    record.EventRecorder
+}
+
+func Register(ctx context.Context, cluster *config.ClusterContext, events *utils.Recorders) error {
+	clusterConfigMapsController, _, err := newConfigMapController("ConfigMapController", cluster.Core.RESTClient(), "")
+	if err != nil {
+		return err
+	}
+	clusterConfigMaps := &configMapLister{indexer: clusterConfigMapsController.Informer().GetIndexer()}
+	copier := &projectcopy.Copier{
+		Kind:                    "config map",
+		Store:                   &configMapStore{client: cluster.K8sClient.CoreV1(), lister: clusterConfigMaps},
+		SourceProjectLabel:      sourceProjectLabel,
+		Events:                  events.Cluster,
+		ReasonRemoved:           reasonRemoved,
+		ReasonPropagationFailed: reasonPropagationFailed,
+		ReasonConflict:          reasonConflict,
+	}
+
+	c := &Controller{
+		copier:                    copier,
+		clusterConfigMaps:         clusterConfigMaps,
+		clusterNamespaceLister:    cluster.Core.Namespaces("").Controller().Lister(),
+		managementNamespaceLister: cluster.Management.Core.Namespaces("").Controller().Lister(),
+		projectLister:             cluster.Management.Management.Projects("").Controller().Lister(),
+		clusterName:               cluster.ClusterName,
+		events:                    events.Cluster,
+	}
+	projects := &projectConfigMaps{
+		ctx:           ctx,
+		restClient:    cluster.Management.Core.RESTClient(),
+		namespaces:    cluster.Management.Core.Namespaces("").Controller(),
+		projectLister: cluster.Management.Management.Projects("").Controller().Lister(),
+		clusterName:   cluster.ClusterName,
+		controllers:   map[string]*projectController{},
+		register: func(managementConfigMapsController controller.GenericController, managementObjectClient *clientbase.ObjectClient) {
+			syncFn := lifecycle.NewObjectLifecycleAdapter("configMapsController_"+cluster.ClusterName, true, &lifecycleAdapter{c}, managementObjectClient)
+			managementConfigMapsController.AddHandler("configMapsController", func(key string) error {
+				obj, exists, err := managementConfigMapsController.Informer().GetStore().GetByKey(key)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return syncFn(key, nil)
+				}
+				if !controller.ObjectInCluster(cluster.ClusterName, obj) {
+					return nil
+				}
+				return syncFn(key, obj.(*corev1.ConfigMap))
+			})
+		},
+	}
+	n := &NamespaceController{
+		copier:               copier,
+		managementConfigMaps: projects,
+		events:               events.Cluster,
+	}
+	cluster.Core.Namespaces("").AddHandler("configMapsController", n.sync)
+	cluster.Management.Core.Namespaces("").AddHandler("projectConfigMapsController", projects.sync)
+	cluster.Management.Management.Projects("").AddClusterScopedHandler("projectConfigMapsController", cluster.ClusterName, projects.syncProject)
+
+	// the cluster context has no config map controllers, the cluster one is synced before the cluster
+	// context starts, the namespace handler reads from its cache. The controllers of the project
+	// namespaces are started by the handler of the management namespaces
+	return controller.SyncThenStart(ctx, 5, clusterConfigMapsController)
+}
+
+// configMapsByNamespace lists the config maps of a namespace
+type configMapsByNamespace interface {
+	List(namespace string) ([]*corev1.ConfigMap, error)
+}
+
+type NamespaceController struct {
+	copier               *projectcopy.Copier
+	managementConfigMaps configMapsByNamespace
+	events               record.EventRecorder
+}
+
+func (n *NamespaceController) sync(key string, obj *corev1.Namespace) error {
+	if obj == nil || obj.DeletionTimestamp != nil {
+		return nil
+	}
+	projectName := projectcopy.ProjectName(obj)
+	if err := n.copier.RemoveOtherProjectCopies(obj, projectName); err != nil {
+		return err
+	}
+	if projectName == "" {
+		return nil
+	}
+
+	// on the managemenet side, config map's namespace name equals to project name
+	configMaps, err := n.managementConfigMaps.List(projectName)
+	if err != nil {
+		return err
+	}
+	for _, configMap := range configMaps {
+		if configMap.DeletionTimestamp != nil {
+			continue
+		}
+		created, err := n.copier.Store.Create(copyConfigMap(configMap, obj.Name))
+		if err != nil && !errors.IsAlreadyExists(err) {
+			n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy config map [%s] of project [%s]: %v", configMap.Name, projectName, err)
+			return err
+		}
+		if err == nil {
+			n.events.Eventf(created, corev1.EventTypeNormal, reasonPropagated, "Copied from project [%s]", projectName)
+		}
+	}
+	return nil
+}
+
+// copyConfigMap returns the copy of a project config map for a namespace of the project
+func copyConfigMap(obj *corev1.ConfigMap, namespace string) *corev1.ConfigMap {
+	namespacedConfigMap := &corev1.ConfigMap{}
+	namespacedConfigMap.Name = obj.Name
+	namespacedConfigMap.Annotations = map[string]string{}
+	for key, value := range obj.Annotations {
+		namespacedConfigMap.Annotations[key] = value
+	}
+	namespacedConfigMap.Annotations[contentHashAnnotation] = contentHash(obj)
+	namespacedConfigMap.Labels = map[string]string{
+		// on the managemenet side, config map's namespace name equals to project name
+		sourceProjectLabel: obj.Namespace,
+		sourceUIDLabel:     string(obj.UID),
+	}
+	namespacedConfigMap.Data = obj.Data
+	namespacedConfigMap.Namespace = namespace
+	return namespacedConfigMap
+}
+
+// contentHash hashes the data of a config map
+func contentHash(configMap *corev1.ConfigMap) string {
+	data := make(map[string][]byte, len(configMap.Data))
+	for key, value := range configMap.Data {
+		data[key] = []byte(value)
+	}
+	hash := sha256.New()
+	projectcopy.HashData(hash, data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isOwnedCopy returns whether the config map is a copy of the source made by the agent, and can be
+// overwritten or deleted
+func isOwnedCopy(configMap *corev1.ConfigMap, source *corev1.ConfigMap) bool {
+	return configMap.Labels[sourceProjectLabel] == source.Namespace
+}
+
+func (c *Controller) remove(obj *corev1.ConfigMap) error {
+	clusterNamespaces, err := c.getClusterNamespaces(obj)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range clusterNamespaces {
+		existing, err := c.clusterConfigMaps.Get(namespace.Name, obj.Name)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !isOwnedCopy(existing, obj) {
+			logrus.Infof("Not deleting config map [%s] in namespace [%s], it is not a copy of project [%s]", obj.Name, namespace.Name, obj.Namespace)
+			continue
+		}
+		logrus.Infof("Deleting config map [%s] in namespace [%s]", obj.Name, namespace.Name)
+		if err := c.copier.Store.Delete(namespace.Name, obj.Name); err != nil && !errors.IsNotFound(err) {
+			c.events.Eventf(namespace, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to delete config map [%s] of project [%s]: %v", obj.Name, obj.Namespace, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) createOrUpdate(obj *corev1.ConfigMap) error {
+	clusterNamespaces, err := c.getClusterNamespaces(obj)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range clusterNamespaces {
+		if namespace.DeletionTimestamp != nil {
+			continue
+		}
+		written, err := c.ensureCopy(obj, namespace)
+		if err != nil {
+			c.events.Eventf(namespace, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy config map [%s] of project [%s]: %v", obj.Name, obj.Namespace, err)
+			return err
+		}
+		if written != nil {
+			c.events.Eventf(written, corev1.EventTypeNormal, reasonPropagated, "Copied from project [%s]", obj.Namespace)
+		}
+	}
+	return nil
+}
+
+// ensureCopy creates or updates the copy of the config map in the namespace, and returns it unless it
+// was already up to date. A config map of the same name the agent doesn't own is never overwritten
+func (c *Controller) ensureCopy(obj *corev1.ConfigMap, namespace *corev1.Namespace) (*corev1.ConfigMap, error) {
+	owned := func(existing projectcopy.Object) bool {
+		return isOwnedCopy(existing.(*corev1.ConfigMap), obj)
+	}
+	upToDate := func(existing projectcopy.Object) bool {
+		hash := contentHash(obj)
+		return existing.GetAnnotations()[contentHashAnnotation] == hash && contentHash(existing.(*corev1.ConfigMap)) == hash &&
+			existing.GetLabels()[sourceUIDLabel] == string(obj.UID)
+	}
+	written, _, err := c.copier.EnsureCopy(obj, namespace, copyConfigMap(obj, namespace.Name), owned, upToDate)
+	if err != nil || written == nil {
+		return nil, err
+	}
+	return written.(*corev1.ConfigMap), nil
+}
+
+func (c *Controller) getClusterNamespaces(obj *corev1.ConfigMap) ([]*corev1.Namespace, error) {
+	return projectcopy.ProjectNamespaces(c.clusterName, obj.Namespace, c.managementNamespaceLister, c.clusterNamespaceLister, c.projectLister)
+}
+
+// lifecycleAdapter runs the lifecycle of the config maps of the projects in management
+type lifecycleAdapter struct {
+	controller *Controller
+}
+
+func (a *lifecycleAdapter) Create(obj runtime.Object) (runtime.Object, error) {
+	return nil, a.controller.createOrUpdate(obj.(*corev1.ConfigMap))
+}
+
+func (a *lifecycleAdapter) Updated(obj runtime.Object) (runtime.Object, error) {
+	return nil, a.controller.createOrUpdate(obj.(*corev1.ConfigMap))
+}
+
+func (a *lifecycleAdapter) Finalize(obj runtime.Object) (runtime.Object, error) {
+	return nil, a.controller.remove(obj.(*corev1.ConfigMap))
+}
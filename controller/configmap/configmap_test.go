@@ -0,0 +1,260 @@
+package configmap
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeNamespaces struct {
+	v1.NamespaceLister
+	namespaces []*corev1.Namespace
+}
+
+func (f *fakeNamespaces) Get(namespace, name string) (*corev1.Namespace, error) {
+	for _, ns := range f.namespaces {
+		if ns.Name == name {
+			return ns, nil
+		}
+	}
+	return nil, errors.NewNotFound(corev1.Resource("namespaces"), name)
+}
+
+func (f *fakeNamespaces) List(namespace string, selector labels.Selector) ([]*corev1.Namespace, error) {
+	return f.namespaces, nil
+}
+
+type fakeProjects struct {
+	v3.ProjectLister
+}
+
+func (f *fakeProjects) Get(namespace, name string) (*v3.Project, error) {
+	return &v3.Project{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+}
+
+// fakeStore reads the config maps of the cluster from the lister, and records the writes
+type fakeStore struct {
+	*configMapStore
+	created []*corev1.ConfigMap
+	updated []*corev1.ConfigMap
+	deleted []string
+}
+
+func (f *fakeStore) GetLive(namespace, name string) (projectcopy.Object, error) {
+	return f.Get(namespace, name)
+}
+
+func (f *fakeStore) Create(obj projectcopy.Object) (projectcopy.Object, error) {
+	f.created = append(f.created, obj.(*corev1.ConfigMap))
+	return obj, nil
+}
+
+func (f *fakeStore) Update(obj projectcopy.Object) (projectcopy.Object, error) {
+	f.updated = append(f.updated, obj.(*corev1.ConfigMap))
+	return obj, nil
+}
+
+func (f *fakeStore) Delete(namespace, name string) error {
+	f.deleted = append(f.deleted, namespace+"/"+name)
+	return nil
+}
+
+type fakeConfigMaps []*corev1.ConfigMap
+
+func (f fakeConfigMaps) List(namespace string) ([]*corev1.ConfigMap, error) {
+	var result []*corev1.ConfigMap
+	for _, configMap := range f {
+		if configMap.Namespace == namespace {
+			result = append(result, configMap)
+		}
+	}
+	return result, nil
+}
+
+func newNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func newConfigMap(namespace, name string, labels map[string]string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Data: data,
+	}
+}
+
+// newTestController returns a controller copying the config maps of project p-1 of cluster c-1 to
+// namespaces ns-1 and ns-2, with the cluster config maps given
+func newTestController(clusterConfigMaps ...*corev1.ConfigMap) (*Controller, *fakeStore) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, configMap := range clusterConfigMaps {
+		indexer.Add(configMap)
+	}
+	lister := &configMapLister{indexer: indexer}
+	store := &fakeStore{configMapStore: &configMapStore{lister: lister}}
+	events := record.NewFakeRecorder(10)
+	c := &Controller{
+		copier: &projectcopy.Copier{
+			Kind:               "config map",
+			Store:              store,
+			SourceProjectLabel: sourceProjectLabel,
+			Events:             events,
+		},
+		clusterConfigMaps: lister,
+		clusterNamespaceLister: &fakeNamespaces{namespaces: []*corev1.Namespace{
+			newNamespace("ns-1", map[string]string{projectcopy.ProjectIDAnnotation: "c-1:p-1"}),
+			newNamespace("ns-2", map[string]string{projectcopy.ProjectIDAnnotation: "c-1:p-1"}),
+			newNamespace("other", map[string]string{projectcopy.ProjectIDAnnotation: "c-1:p-2"}),
+		}},
+		managementNamespaceLister: &fakeNamespaces{namespaces: []*corev1.Namespace{
+			newNamespace("p-1", map[string]string{projectcopy.ProjectNamespaceAnnotation: "true"}),
+		}},
+		projectLister: &fakeProjects{},
+		clusterName:   "c-1",
+		events:        events,
+	}
+	return c, store
+}
+
+func TestCreateOrUpdate(t *testing.T) {
+	source := newConfigMap("p-1", "settings", nil, map[string]string{"key": "value"})
+	source.UID = "uid-1"
+	upToDate := copyConfigMap(source, "ns-2")
+	outdated := copyConfigMap(source, "ns-2")
+	outdated.Data = map[string]string{"key": "old"}
+	notCopy := newConfigMap("ns-2", "settings", nil, map[string]string{"key": "users"})
+
+	tests := []struct {
+		name        string
+		existing    *corev1.ConfigMap
+		wantCreated []string
+		wantUpdated []string
+	}{
+		{name: "copy", wantCreated: []string{"ns-1", "ns-2"}},
+		{name: "update", existing: outdated, wantCreated: []string{"ns-1"}, wantUpdated: []string{"ns-2"}},
+		{name: "up to date", existing: upToDate, wantCreated: []string{"ns-1"}},
+		{name: "not a copy", existing: notCopy, wantCreated: []string{"ns-1"}},
+	}
+
+	for _, test := range tests {
+		var clusterConfigMaps []*corev1.ConfigMap
+		if test.existing != nil {
+			clusterConfigMaps = append(clusterConfigMaps, test.existing)
+		}
+		c, store := newTestController(clusterConfigMaps...)
+
+		if err := c.createOrUpdate(source); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := namespaces(store.created); !reflect.DeepEqual(got, test.wantCreated) {
+			t.Errorf("%s: got created in %v, want %v", test.name, got, test.wantCreated)
+		}
+		if got := namespaces(store.updated); !reflect.DeepEqual(got, test.wantUpdated) {
+			t.Errorf("%s: got updated in %v, want %v", test.name, got, test.wantUpdated)
+		}
+		for _, written := range append(store.created, store.updated...) {
+			if !reflect.DeepEqual(written.Data, source.Data) || written.Labels[sourceProjectLabel] != "p-1" || written.Labels[sourceUIDLabel] != "uid-1" {
+				t.Errorf("%s: got copy %+v", test.name, written)
+			}
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	source := newConfigMap("p-1", "settings", nil, nil)
+	c, store := newTestController(
+		copyConfigMap(source, "ns-1"),
+		newConfigMap("ns-2", "settings", nil, nil),
+		newConfigMap("other", "settings", map[string]string{sourceProjectLabel: "p-1"}, nil),
+	)
+
+	if err := c.remove(source); err != nil {
+		t.Fatal(err)
+	}
+	// the config map of users in ns-2 is kept, other isn't in the project
+	if want := []string{"ns-1/settings"}; !reflect.DeepEqual(store.deleted, want) {
+		t.Errorf("got deleted %v, want %v", store.deleted, want)
+	}
+}
+
+func TestNamespaceSync(t *testing.T) {
+	c, store := newTestController(newConfigMap("ns-1", "users", nil, nil))
+	deleted := newConfigMap("p-1", "deleted", nil, nil)
+	deleted.DeletionTimestamp = &metav1.Time{}
+	n := &NamespaceController{
+		copier: c.copier,
+		managementConfigMaps: fakeConfigMaps{
+			newConfigMap("p-1", "settings", nil, map[string]string{"key": "value"}),
+			deleted,
+			newConfigMap("p-2", "other", nil, nil),
+		},
+		events: record.NewFakeRecorder(10),
+	}
+
+	if err := n.sync("ns-1", newNamespace("ns-1", map[string]string{projectcopy.ProjectIDAnnotation: "c-1:p-1"})); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.created) != 1 || store.created[0].Namespace != "ns-1" || store.created[0].Name != "settings" {
+		t.Errorf("got created %v, want settings in ns-1", store.created)
+	}
+	if len(store.deleted) != 0 {
+		t.Errorf("got deleted %v, want the config maps of users kept", store.deleted)
+	}
+}
+
+func TestIsOwnedCopy(t *testing.T) {
+	source := newConfigMap("p-1", "settings", nil, map[string]string{"key": "value"})
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "copy", labels: map[string]string{sourceProjectLabel: "p-1"}, want: true},
+		{name: "copy of another project", labels: map[string]string{sourceProjectLabel: "p-2"}},
+		{name: "unlabeled config map with the same content"},
+	}
+
+	for _, test := range tests {
+		if got := isOwnedCopy(newConfigMap("ns-1", "settings", test.labels, source.Data), source); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func namespaces(configMaps []*corev1.ConfigMap) []string {
+	var result []string
+	for _, configMap := range configMaps {
+		result = append(result, configMap.Namespace)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestProjectConfigMapsIgnoresOtherNamespaces(t *testing.T) {
+	p := &projectConfigMaps{projectLister: &fakeProjects{}, clusterName: "c-1", controllers: map[string]*projectController{}}
+
+	if err := p.sync("cattle-system", newNamespace("cattle-system", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.controllers) != 0 {
+		t.Errorf("got controllers %v, want none for a namespace that isn't a project namespace", p.controllers)
+	}
+	// the namespace handler retries until the config maps of the project are cached
+	if _, err := p.List("p-1"); err == nil {
+		t.Error("got no error listing the config maps of a namespace that isn't watched")
+	}
+}
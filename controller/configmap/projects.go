@@ -0,0 +1,112 @@
+package configmap
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/norman/clientbase"
+	"github.com/rancher/norman/controller"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+)
+
+// projectConfigMaps runs a controller of the config maps of every project namespace of the cluster in
+// management, so only the config maps of the projects are cached. A controller runs until its
+// namespace is gone, the finalizers of its config maps are removed before
+type projectConfigMaps struct {
+	sync.Mutex
+	ctx           context.Context
+	restClient    rest.Interface
+	namespaces    v1.NamespaceController
+	projectLister v3.ProjectLister
+	clusterName   string
+	// register adds the handlers to the controller of a project namespace
+	register    func(c controller.GenericController, objectClient *clientbase.ObjectClient)
+	controllers map[string]*projectController
+}
+
+type projectController struct {
+	controller controller.GenericController
+	cancel     context.CancelFunc
+}
+
+// sync starts the controller of the config maps of the namespace once it is the namespace of a project
+// of the cluster, and stops it when the namespace is gone
+func (p *projectConfigMaps) sync(key string, obj *corev1.Namespace) error {
+	if obj == nil {
+		p.stop(key)
+		return nil
+	}
+	if obj.Annotations[projectcopy.ProjectNamespaceAnnotation] != "true" {
+		return nil
+	}
+	// project namespace name = project name, the namespace is enqueued again once the project exists
+	if _, err := p.projectLister.Get(p.clusterName, obj.Name); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return p.start(obj.Name)
+}
+
+// syncProject enqueues the namespace of the project, which can be created before the project
+func (p *projectConfigMaps) syncProject(key string, obj *v3.Project) error {
+	if obj != nil {
+		p.namespaces.Enqueue("", obj.Name)
+	}
+	return nil
+}
+
+func (p *projectConfigMaps) start(namespace string) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.controllers[namespace]; ok {
+		return nil
+	}
+
+	c, objectClient, err := newConfigMapController("ManagementConfigMapController_"+namespace, p.restClient, namespace)
+	if err != nil {
+		return err
+	}
+	p.register(c, objectClient)
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.controllers[namespace] = &projectController{controller: c, cancel: cancel}
+
+	logrus.Infof("Watching the config maps of project namespace [%s]", namespace)
+	go func() {
+		if err := controller.SyncThenStart(ctx, 5, c); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Failed to start the config map controller of project namespace [%s]: %v", namespace, err)
+		}
+	}()
+	return nil
+}
+
+func (p *projectConfigMaps) stop(namespace string) {
+	p.Lock()
+	defer p.Unlock()
+	if c, ok := p.controllers[namespace]; ok {
+		logrus.Infof("Stopped watching the config maps of project namespace [%s]", namespace)
+		c.cancel()
+		delete(p.controllers, namespace)
+	}
+}
+
+// List returns the config maps of the project namespace from the cache of its controller
+func (p *projectConfigMaps) List(namespace string) ([]*corev1.ConfigMap, error) {
+	p.Lock()
+	c, ok := p.controllers[namespace]
+	p.Unlock()
+	if !ok {
+		return nil, errors.Errorf("config maps of project namespace [%s] aren't watched yet", namespace)
+	}
+	if !c.controller.Informer().HasSynced() {
+		return nil, errors.Errorf("config maps of project namespace [%s] aren't synced yet", namespace)
+	}
+	return (&configMapLister{indexer: c.controller.Informer().GetIndexer()}).List(namespace)
+}
@@ -0,0 +1,124 @@
+package configmap
+
+import (
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/norman/clientbase"
+	"github.com/rancher/norman/controller"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The clients of rancher/types have no ConfigMaps. Config maps are written with the typed client of
+// client-go, and read from the informers of generic controllers
+
+var configMapResource = metav1.APIResource{
+	Name:         "configmaps",
+	SingularName: "configmap",
+	Namespaced:   true,
+	Kind:         "ConfigMap",
+}
+
+type configMapFactory struct{}
+
+func (configMapFactory) Object() runtime.Object {
+	return &corev1.ConfigMap{}
+}
+
+func (configMapFactory) List() runtime.Object {
+	return &corev1.ConfigMapList{}
+}
+
+// newConfigMapController returns the controller of the config maps of the namespace served by the REST
+// client, of all namespaces if empty, with the object client its lifecycle handlers update the config maps with
+func newConfigMapController(name string, restClient rest.Interface, namespace string) (controller.GenericController, *clientbase.ObjectClient, error) {
+	objectClient := clientbase.NewObjectClient(namespace, restClient, &configMapResource, corev1.SchemeGroupVersion.WithKind(configMapResource.Kind), configMapFactory{})
+	c := controller.NewGenericController(name, objectClient)
+	if err := c.Informer().AddIndexers(cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}); err != nil {
+		return nil, nil, err
+	}
+	return c, objectClient, nil
+}
+
+// configMapLister reads config maps from the cache of a controller
+type configMapLister struct {
+	indexer cache.Indexer
+}
+
+func (l *configMapLister) List(namespace string) ([]*corev1.ConfigMap, error) {
+	var configMaps []*corev1.ConfigMap
+	err := cache.ListAllByNamespace(l.indexer, namespace, labels.Everything(), func(obj interface{}) {
+		configMaps = append(configMaps, obj.(*corev1.ConfigMap))
+	})
+	return configMaps, err
+}
+
+func (l *configMapLister) Get(namespace, name string) (*corev1.ConfigMap, error) {
+	obj, exists, err := l.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(corev1.Resource(configMapResource.Name), name)
+	}
+	return obj.(*corev1.ConfigMap), nil
+}
+
+// configMapStore reads the config maps of the cluster from the cache and writes them with the client
+type configMapStore struct {
+	client v1.ConfigMapsGetter
+	lister *configMapLister
+}
+
+func (s *configMapStore) Get(namespace, name string) (projectcopy.Object, error) {
+	configMap, err := s.lister.Get(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (s *configMapStore) List(namespace string) ([]projectcopy.Object, error) {
+	configMaps, err := s.lister.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]projectcopy.Object, 0, len(configMaps))
+	for _, configMap := range configMaps {
+		objs = append(objs, configMap)
+	}
+	return objs, nil
+}
+
+func (s *configMapStore) GetLive(namespace, name string) (projectcopy.Object, error) {
+	configMap, err := s.client.ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (s *configMapStore) Create(obj projectcopy.Object) (projectcopy.Object, error) {
+	configMap, err := s.client.ConfigMaps(obj.GetNamespace()).Create(obj.(*corev1.ConfigMap))
+	if err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (s *configMapStore) Update(obj projectcopy.Object) (projectcopy.Object, error) {
+	configMap, err := s.client.ConfigMaps(obj.GetNamespace()).Update(obj.(*corev1.ConfigMap))
+	if err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (s *configMapStore) Delete(namespace, name string) error {
+	return s.client.ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+}
@@ -4,21 +4,38 @@ import (
 	"context"
 
 	"github.com/rancher/cluster-agent/controller/authz"
+	"github.com/rancher/cluster-agent/controller/configmap"
 	"github.com/rancher/cluster-agent/controller/eventssyncer"
 	"github.com/rancher/cluster-agent/controller/healthsyncer"
 	"github.com/rancher/cluster-agent/controller/nodesyncer"
 	"github.com/rancher/cluster-agent/controller/secret"
+	"github.com/rancher/cluster-agent/utils"
 	helmController "github.com/rancher/helm-controller/controller"
 	"github.com/rancher/types/config"
 	workloadController "github.com/rancher/workload-controller/controller"
 )
 
-func Register(ctx context.Context, cluster *config.ClusterContext) error {
-	nodesyncer.Register(cluster)
+// Options holds the tunables of the agent controllers
+type Options struct {
+	NodeSyncer   nodesyncer.Options
+	EventsSyncer eventssyncer.Options
+	Secret       secret.Options
+}
+
+func Register(ctx context.Context, cluster *config.ClusterContext, opts *Options) error {
+	events := utils.NewRecorders(ctx, cluster)
+	nodesyncer.Register(ctx, cluster, opts.NodeSyncer, events)
 	healthsyncer.Register(ctx, cluster)
-	authz.Register(cluster)
-	eventssyncer.Register(cluster)
-	secret.Register(cluster)
+	authz.Register(cluster, events)
+	if err := eventssyncer.Register(ctx, cluster, opts.EventsSyncer); err != nil {
+		return err
+	}
+	if err := secret.Register(ctx, cluster, opts.Secret, events); err != nil {
+		return err
+	}
+	if err := configmap.Register(ctx, cluster, events); err != nil {
+		return err
+	}
 	helmController.Register(cluster)
 
 	workloadContext := cluster.WorkloadContext()
@@ -0,0 +1,377 @@
+package eventssyncer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/norman/condition"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	alertsConfigKey       = "event-alerts"
+	alertEvaluateInterval = 30 * time.Second
+	defaultAlertWindow    = 10 * time.Minute
+
+	alertTargetCluster = "cluster"
+	alertTargetProject = "project"
+	alertTargetEvent   = "event"
+
+	// alertConditionPrefix prefixes the rule name in the type of the condition a rule raises
+	alertConditionPrefix = "EventAlert"
+	// alertSeverityAnnotation marks the ClusterEvents raised by alert rules
+	alertSeverityAnnotation = "eventssyncer.cattle.io/severity"
+	alertSeverityHigh       = "high"
+	alertReasonFiring       = "EventAlert"
+	alertReasonResolved     = "EventAlertResolved"
+)
+
+var (
+	// alertTransitions counts the times each alert rule fired and resolved
+	alertTransitions = expvar.NewMap("eventssyncer_alerts")
+)
+
+// AlertRule fires once Count events matching all of its non empty criteria are seen within Window,
+// and resolves once they are not anymore. Firing rules set the condition EventAlert<Name> to True on
+// the Cluster or on the Project of the events, or write a high severity ClusterEvent
+type AlertRule struct {
+	Name       string   `json:"name"`
+	Types      []string `json:"types,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Count      int      `json:"count,omitempty"`
+	Window     string   `json:"window,omitempty"`
+	Target     string   `json:"target,omitempty"`
+}
+
+type alertsConfig struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+type alertRule struct {
+	AlertRule
+	window time.Duration
+}
+
+// alertState tracks the occurrences matching a rule in one scope, the cluster or a project
+type alertState struct {
+	rule        *alertRule
+	projectID   string
+	occurrences []alertOccurrence
+	counts      map[types.UID]int32
+	firing      bool
+	sample      *corev1.Event
+}
+
+type alertOccurrence struct {
+	seen   time.Time
+	weight int
+}
+
+// Alert is a rule that started or stopped firing in a scope
+type Alert struct {
+	Rule      *AlertRule
+	ProjectID string
+	Firing    bool
+	Count     int
+	Sample    *corev1.Event
+}
+
+// Alerts evaluates the alert rules against the events seen by the syncer
+type Alerts struct {
+	sync.Mutex
+	rules     map[string]*alertRule
+	states    map[string]*alertState
+	projectID func(event *corev1.Event) string
+	raise     func(alert *Alert) error
+}
+
+func newAlerts(projectID func(event *corev1.Event) string, raise func(alert *Alert) error) *Alerts {
+	return &Alerts{
+		rules:     map[string]*alertRule{},
+		states:    map[string]*alertState{},
+		projectID: projectID,
+		raise:     raise,
+	}
+}
+
+func (a *Alerts) load(value string) error {
+	config := &alertsConfig{}
+	if err := unmarshalConfig(value, config); err != nil {
+		return err
+	}
+
+	rules := map[string]*alertRule{}
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			return errors.Errorf("event alert rule %d has no name", i)
+		}
+		if rule.Target == "" {
+			rule.Target = alertTargetCluster
+		}
+		if rule.Target != alertTargetCluster && rule.Target != alertTargetProject && rule.Target != alertTargetEvent {
+			return errors.Errorf("event alert rule [%s] has invalid target [%s]", rule.Name, rule.Target)
+		}
+		if rule.Count <= 0 {
+			rule.Count = 1
+		}
+		window := defaultAlertWindow
+		if rule.Window != "" {
+			parsed, err := time.ParseDuration(rule.Window)
+			if err != nil {
+				return errors.Wrapf(err, "invalid window of event alert rule [%s]", rule.Name)
+			}
+			window = parsed
+		}
+		rules[rule.Name] = &alertRule{AlertRule: rule, window: window}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.rules = rules
+	return nil
+}
+
+// observe records the event against every rule it matches
+func (a *Alerts) observe(event *corev1.Event) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	var projectID *string
+	for _, rule := range a.rules {
+		if !rule.matches(event) {
+			continue
+		}
+		scope := ""
+		if rule.Target != alertTargetCluster {
+			if projectID == nil {
+				id := a.projectID(event)
+				projectID = &id
+			}
+			if *projectID == "" && rule.Target == alertTargetProject {
+				continue
+			}
+			scope = *projectID
+		}
+
+		key := rule.Name + "/" + scope
+		state, ok := a.states[key]
+		if !ok {
+			state = &alertState{
+				rule:      rule,
+				projectID: scope,
+				counts:    map[types.UID]int32{},
+			}
+			a.states[key] = state
+		}
+		state.observe(event)
+	}
+}
+
+// observe counts the occurrences of the event since it was last seen, a repeating event
+// being synced with a higher count
+func (s *alertState) observe(event *corev1.Event) {
+	weight := 1
+	if last, ok := s.counts[event.UID]; ok {
+		if event.Count <= last {
+			return
+		}
+		weight = int(event.Count - last)
+	}
+	s.counts[event.UID] = event.Count
+	s.occurrences = append(s.occurrences, alertOccurrence{seen: time.Now(), weight: weight})
+	s.sample = event
+}
+
+// total drops the occurrences out of the window, and returns the weight of the others
+func (s *alertState) total(now time.Time) int {
+	i := 0
+	for i < len(s.occurrences) && now.Sub(s.occurrences[i].seen) > s.rule.window {
+		i++
+	}
+	s.occurrences = s.occurrences[i:]
+	if len(s.occurrences) == 0 {
+		s.counts = map[types.UID]int32{}
+	}
+
+	total := 0
+	for _, occurrence := range s.occurrences {
+		total += occurrence.weight
+	}
+	return total
+}
+
+func (a *Alerts) evaluate(ctx context.Context, interval time.Duration) {
+	for range utils.TickerContext(ctx, interval) {
+		a.evaluateAll()
+	}
+}
+
+// evaluateAll raises the rules that started firing, and resolves the ones that stopped.
+// Rules removed from the config are resolved too
+func (a *Alerts) evaluateAll() {
+	type transition struct {
+		key   string
+		alert *Alert
+	}
+	var transitions []transition
+
+	now := time.Now()
+	a.Lock()
+	for key, state := range a.states {
+		total := 0
+		rule, ok := a.rules[state.rule.Name]
+		if ok {
+			// pick up the latest window and count of the rule
+			state.rule = rule
+			total = state.total(now)
+		}
+		firing := ok && total >= state.rule.Count
+		if firing != state.firing {
+			transitions = append(transitions, transition{
+				key: key,
+				alert: &Alert{
+					Rule:      &state.rule.AlertRule,
+					ProjectID: state.projectID,
+					Firing:    firing,
+					Count:     total,
+					Sample:    state.sample,
+				},
+			})
+		} else if !firing && (!ok || len(state.occurrences) == 0) {
+			delete(a.states, key)
+		}
+	}
+	a.Unlock()
+
+	for _, t := range transitions {
+		if err := a.raise(t.alert); err != nil {
+			logrus.Warnf("Failed to raise event alert [%s]: %v", t.alert.Rule.Name, err)
+			continue
+		}
+		if t.alert.Firing {
+			alertTransitions.Add(t.alert.Rule.Name+".fired", 1)
+		} else {
+			alertTransitions.Add(t.alert.Rule.Name+".resolved", 1)
+		}
+
+		a.Lock()
+		if state, ok := a.states[t.key]; ok {
+			state.firing = t.alert.Firing
+		}
+		a.Unlock()
+	}
+}
+
+func (r *alertRule) matches(event *corev1.Event) bool {
+	return matchesAny(r.Types, event.Type) &&
+		matchesAny(r.Reasons, event.Reason) &&
+		matchesAny(r.Kinds, event.InvolvedObject.Kind) &&
+		matchesAny(r.Namespaces, event.InvolvedObject.Namespace)
+}
+
+// raiseAlert applies the firing or resolved alert to its target
+func (e *EventsSyncer) raiseAlert(alert *Alert) error {
+	switch alert.Rule.Target {
+	case alertTargetProject:
+		parts := strings.SplitN(alert.ProjectID, ":", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("invalid project id [%s]", alert.ProjectID)
+		}
+		project, err := e.projects.Get(parts[0], parts[1])
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get project [%s]", alert.ProjectID)
+		}
+		project = project.DeepCopy()
+		setAlertCondition(project, alert)
+		_, err = e.projectsClient.Update(project)
+		return err
+	case alertTargetEvent:
+		return e.createGeneratedClusterEvent(newAlertEvent(alert))
+	}
+
+	cluster, err := e.clusters.Get("", e.clusterName)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get cluster [%s]", e.clusterName)
+	}
+	cluster = cluster.DeepCopy()
+	setAlertCondition(cluster, alert)
+	_, err = e.clustersClient.Update(cluster)
+	return err
+}
+
+func setAlertCondition(obj runtime.Object, alert *Alert) {
+	cond := condition.Cond(alertConditionPrefix + alert.Rule.Name)
+	if alert.Firing {
+		cond.True(obj)
+		cond.Reason(obj, alertReason(alert))
+		cond.Message(obj, alertMessage(alert))
+	} else {
+		cond.False(obj)
+		cond.Reason(obj, "Resolved")
+		cond.Message(obj, "")
+	}
+	cond.LastUpdated(obj, time.Now().UTC().Format(time.RFC3339))
+}
+
+// newAlertEvent describes the alert as a high severity event about the object of the last matching event
+func newAlertEvent(alert *Alert) *corev1.Event {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "event-alert-",
+			Namespace:    alert.Sample.Namespace,
+			Annotations: map[string]string{
+				alertSeverityAnnotation: alertSeverityHigh,
+			},
+		},
+		InvolvedObject: alert.Sample.InvolvedObject,
+		Reason:         alertReasonFiring,
+		Message:        alertMessage(alert),
+		Source:         corev1.EventSource{Component: alert.Rule.Name},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           corev1.EventTypeWarning,
+	}
+	if !alert.Firing {
+		event.Reason = alertReasonResolved
+		event.Message = fmt.Sprintf("Alert [%s] resolved", alert.Rule.Name)
+		event.Type = corev1.EventTypeNormal
+	}
+	return event
+}
+
+func alertReason(alert *Alert) string {
+	if alert.Sample.Reason != "" {
+		return alert.Sample.Reason
+	}
+	return alertReasonFiring
+}
+
+func alertMessage(alert *Alert) string {
+	object := alert.Sample.InvolvedObject
+	return fmt.Sprintf("%d events matching alert [%s] in the last %s, last on %s %s/%s: %s",
+		alert.Count, alert.Rule.Name, alertWindow(alert.Rule), object.Kind, object.Namespace, object.Name, alert.Sample.Message)
+}
+
+func alertWindow(rule *AlertRule) string {
+	if rule.Window == "" {
+		return defaultAlertWindow.String()
+	}
+	return rule.Window
+}
@@ -0,0 +1,168 @@
+package eventssyncer
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const alertRules = `
+rules:
+- name: backoff
+  reasons: [BackOff]
+  count: 3
+  window: 5m
+- name: project-failures
+  types: [Warning]
+  reasons: [Failed]
+  target: project
+`
+
+func observeEvent(a *Alerts, namespace, reason string, uid types.UID, count int32) {
+	a.observe(&corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{UID: uid},
+		Type:       corev1.EventTypeWarning,
+		Reason:     reason,
+		Count:      count,
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      "pod-1",
+		},
+	})
+}
+
+type raisedAlerts struct {
+	alerts []*Alert
+}
+
+func (r *raisedAlerts) raise(alert *Alert) error {
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func newTestAlerts(t *testing.T) (*Alerts, *raisedAlerts) {
+	raised := &raisedAlerts{}
+	a := newAlerts(func(event *corev1.Event) string {
+		if event.InvolvedObject.Namespace == "in-project" {
+			return "c-1:p-1"
+		}
+		return ""
+	}, raised.raise)
+	if err := a.load(alertRules); err != nil {
+		t.Fatal(err)
+	}
+	return a, raised
+}
+
+func TestAlertFiresAndResolves(t *testing.T) {
+	a, raised := newTestAlerts(t)
+
+	observeEvent(a, "default", "BackOff", "uid-1", 1)
+	observeEvent(a, "default", "BackOff", "uid-1", 1)
+	a.evaluateAll()
+	if len(raised.alerts) != 0 {
+		t.Fatalf("got %d alerts for one occurrence, the same count synced twice", len(raised.alerts))
+	}
+
+	// the repeating event counts for the occurrences since it was last seen
+	observeEvent(a, "default", "BackOff", "uid-1", 3)
+	a.evaluateAll()
+	if len(raised.alerts) != 1 {
+		t.Fatalf("got %d alerts, want the rule to fire", len(raised.alerts))
+	}
+	alert := raised.alerts[0]
+	if !alert.Firing || alert.Rule.Name != "backoff" || alert.Count != 3 || alert.ProjectID != "" {
+		t.Errorf("got alert %+v", alert)
+	}
+
+	// still firing, no transition
+	a.evaluateAll()
+	if len(raised.alerts) != 1 {
+		t.Fatalf("got %d alerts, want no transition while firing", len(raised.alerts))
+	}
+
+	// the occurrences get out of the window
+	for _, state := range a.states {
+		for i := range state.occurrences {
+			state.occurrences[i].seen = time.Now().Add(-10 * time.Minute)
+		}
+	}
+	a.evaluateAll()
+	if len(raised.alerts) != 2 || raised.alerts[1].Firing {
+		t.Fatalf("got alerts %v, want the rule resolved", raised.alerts)
+	}
+
+	// resolved and without occurrences, the state is dropped
+	a.evaluateAll()
+	if len(a.states) != 0 {
+		t.Errorf("got %d alert states kept", len(a.states))
+	}
+}
+
+func TestAlertProjectScope(t *testing.T) {
+	a, raised := newTestAlerts(t)
+
+	observeEvent(a, "in-project", "Failed", "uid-1", 1)
+	// not in a project, a project rule can't apply
+	observeEvent(a, "default", "Failed", "uid-2", 1)
+	a.evaluateAll()
+
+	if len(raised.alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(raised.alerts))
+	}
+	if alert := raised.alerts[0]; alert.ProjectID != "c-1:p-1" || alert.Rule.Target != alertTargetProject {
+		t.Errorf("got alert %+v", alert)
+	}
+}
+
+func TestAlertRuleRemoved(t *testing.T) {
+	a, raised := newTestAlerts(t)
+	observeEvent(a, "in-project", "Failed", "uid-1", 1)
+	a.evaluateAll()
+
+	if err := a.load(""); err != nil {
+		t.Fatal(err)
+	}
+	a.evaluateAll()
+	if len(raised.alerts) != 2 || raised.alerts[1].Firing {
+		t.Fatalf("got alerts %v, want the removed rule resolved", raised.alerts)
+	}
+	a.evaluateAll()
+	if len(a.states) != 0 {
+		t.Errorf("got %d alert states of removed rules", len(a.states))
+	}
+}
+
+func TestAlertsLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", value: alertRules},
+		{name: "no name", value: "rules: [{reasons: [BackOff]}]", wantErr: true},
+		{name: "invalid target", value: "rules: [{name: a, target: node}]", wantErr: true},
+		{name: "invalid window", value: "rules: [{name: a, window: soon}]", wantErr: true},
+	}
+
+	for _, test := range tests {
+		a := newAlerts(nil, nil)
+		err := a.load(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+
+	a := newAlerts(nil, nil)
+	if err := a.load("rules: [{name: a}]"); err != nil {
+		t.Fatal(err)
+	}
+	rule := a.rules["a"]
+	if rule.Target != alertTargetCluster || rule.Count != 1 || rule.window != defaultAlertWindow {
+		t.Errorf("got defaults %+v", rule)
+	}
+}
@@ -0,0 +1,100 @@
+package eventssyncer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	collectInterval = 5 * time.Minute
+	// collectBatchSize is the maximum number of ClusterEvents deleted in one collection
+	collectBatchSize = 200
+)
+
+// Collector deletes the ClusterEvents created by this agent once they are past their
+// retention, either by age or by count in their project namespace
+type Collector struct {
+	clusterName         string
+	clusterEvents       v3.ClusterEventLister
+	clusterEventsClient v3.ClusterEventInterface
+	maxAge              time.Duration
+	maxCount            int
+	debouncer           *utils.Debouncer
+}
+
+func (c *Collector) collect(ctx context.Context, interval time.Duration) {
+	for range utils.TickerContext(ctx, interval) {
+		if err := c.collectExpired(); err != nil {
+			logrus.Infof("Failed to collect expired cluster events: %v", err)
+		}
+	}
+}
+
+func (c *Collector) collectExpired() error {
+	selector := labels.Set(map[string]string{clusterEventOwnerLabel: c.clusterName}).AsSelector()
+	clusterEvents, err := c.clusterEvents.List("", selector)
+	if err != nil {
+		return err
+	}
+
+	byNamespace := map[string][]*v3.ClusterEvent{}
+	for _, clusterEvent := range clusterEvents {
+		byNamespace[clusterEvent.Namespace] = append(byNamespace[clusterEvent.Namespace], clusterEvent)
+	}
+
+	var expired []*v3.ClusterEvent
+	for _, namespaceEvents := range byNamespace {
+		expired = append(expired, c.getExpired(namespaceEvents)...)
+	}
+	if len(expired) > collectBatchSize {
+		logrus.Debugf("Deferring deletion of %d expired cluster events to the next collection", len(expired)-collectBatchSize)
+		expired = expired[:collectBatchSize]
+	}
+
+	for _, clusterEvent := range expired {
+		err := c.clusterEventsClient.DeleteNamespaced(clusterEvent.Namespace, clusterEvent.Name, nil)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		c.debouncer.Forget(clusterEvent.Namespace + "/" + clusterEvent.Name)
+	}
+	if len(expired) > 0 {
+		logrus.Infof("Deleted %d expired cluster events of cluster [%s]", len(expired), c.clusterName)
+	}
+	return nil
+}
+
+// getExpired returns the events of a namespace that are past the retention, oldest first
+func (c *Collector) getExpired(clusterEvents []*v3.ClusterEvent) []*v3.ClusterEvent {
+	sort.Slice(clusterEvents, func(i, j int) bool {
+		return lastSeen(clusterEvents[i]).After(lastSeen(clusterEvents[j]))
+	})
+
+	var expired []*v3.ClusterEvent
+	for i, clusterEvent := range clusterEvents {
+		tooOld := c.maxAge > 0 && time.Since(lastSeen(clusterEvent)) > c.maxAge
+		tooMany := c.maxCount > 0 && i >= c.maxCount
+		if tooOld || tooMany {
+			expired = append(expired, clusterEvent)
+		}
+	}
+	// newest first, so reverse to delete the oldest first
+	for i, j := 0, len(expired)-1; i < j; i, j = i+1, j-1 {
+		expired[i], expired[j] = expired[j], expired[i]
+	}
+	return expired
+}
+
+func lastSeen(clusterEvent *v3.ClusterEvent) time.Time {
+	if !clusterEvent.LastTimestamp.IsZero() {
+		return clusterEvent.LastTimestamp.Time
+	}
+	return clusterEvent.CreationTimestamp.Time
+}
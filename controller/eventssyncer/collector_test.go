@@ -0,0 +1,181 @@
+package eventssyncer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeClusterEvents is the lister of the ClusterEvents, and records the writes of its client
+type fakeClusterEvents struct {
+	v3.ClusterEventLister
+	clusterEvents []*v3.ClusterEvent
+	created       []*v3.ClusterEvent
+	updated       []*v3.ClusterEvent
+	deleted       []string
+	createErr     error
+}
+
+func (f *fakeClusterEvents) List(namespace string, selector labels.Selector) ([]*v3.ClusterEvent, error) {
+	var result []*v3.ClusterEvent
+	for _, clusterEvent := range f.clusterEvents {
+		if (namespace == "" || clusterEvent.Namespace == namespace) && selector.Matches(labels.Set(clusterEvent.Labels)) {
+			result = append(result, clusterEvent)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeClusterEvents) Get(namespace, name string) (*v3.ClusterEvent, error) {
+	for _, clusterEvent := range f.clusterEvents {
+		if clusterEvent.Namespace == namespace && clusterEvent.Name == name {
+			return clusterEvent, nil
+		}
+	}
+	return nil, notFound(name)
+}
+
+type fakeClusterEventsClient struct {
+	v3.ClusterEventInterface
+	store *fakeClusterEvents
+}
+
+func (f *fakeClusterEvents) client() *fakeClusterEventsClient {
+	return &fakeClusterEventsClient{store: f}
+}
+
+func (f *fakeClusterEventsClient) Create(clusterEvent *v3.ClusterEvent) (*v3.ClusterEvent, error) {
+	if f.store.createErr != nil {
+		return nil, f.store.createErr
+	}
+	f.store.created = append(f.store.created, clusterEvent)
+	return clusterEvent, nil
+}
+
+func (f *fakeClusterEventsClient) Update(clusterEvent *v3.ClusterEvent) (*v3.ClusterEvent, error) {
+	f.store.updated = append(f.store.updated, clusterEvent)
+	return clusterEvent, nil
+}
+
+func (f *fakeClusterEventsClient) DeleteNamespaced(namespace, name string, options *metav1.DeleteOptions) error {
+	f.store.deleted = append(f.store.deleted, namespace+"/"+name)
+	return nil
+}
+
+func notFound(name string) error {
+	return apierrors.NewNotFound(schema.GroupResource{}, name)
+}
+
+func newClusterEvent(namespace, name string, lastSeen time.Time) *v3.ClusterEvent {
+	clusterEvent := &v3.ClusterEvent{}
+	clusterEvent.Namespace = namespace
+	clusterEvent.Name = name
+	clusterEvent.Labels = map[string]string{clusterEventOwnerLabel: "c-1"}
+	clusterEvent.LastTimestamp = metav1.NewTime(lastSeen)
+	return clusterEvent
+}
+
+func TestGetExpired(t *testing.T) {
+	now := time.Now()
+	events := func() []*v3.ClusterEvent {
+		var clusterEvents []*v3.ClusterEvent
+		// listed in no particular order
+		for _, age := range []int{3, 1, 5, 2, 4} {
+			clusterEvents = append(clusterEvents, newClusterEvent("p-1", fmt.Sprintf("age-%d", age), now.Add(-time.Duration(age)*time.Hour)))
+		}
+		return clusterEvents
+	}
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		maxCount int
+		want     []string
+	}{
+		{
+			name: "no retention",
+		},
+		{
+			name:   "by age",
+			maxAge: 150 * time.Minute,
+			want:   []string{"age-5", "age-4", "age-3"},
+		},
+		{
+			name:     "by count",
+			maxCount: 3,
+			want:     []string{"age-5", "age-4"},
+		},
+		{
+			name:     "by age and count",
+			maxAge:   270 * time.Minute,
+			maxCount: 2,
+			want:     []string{"age-5", "age-4", "age-3"},
+		},
+	}
+
+	for _, test := range tests {
+		c := &Collector{maxAge: test.maxAge, maxCount: test.maxCount}
+		var got []string
+		for _, clusterEvent := range c.getExpired(events()) {
+			got = append(got, clusterEvent.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLastSeen(t *testing.T) {
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	clusterEvent := &v3.ClusterEvent{}
+	clusterEvent.CreationTimestamp = metav1.NewTime(created)
+	if got := lastSeen(clusterEvent); !got.Equal(created) {
+		t.Errorf("got %v without a last timestamp, want the creation time %v", got, created)
+	}
+}
+
+func TestCollectExpired(t *testing.T) {
+	now := time.Now()
+	foreign := newClusterEvent("p-1", "foreign", now.Add(-5*time.Hour))
+	foreign.Labels[clusterEventOwnerLabel] = "c-2"
+	clusterEvents := &fakeClusterEvents{
+		clusterEvents: []*v3.ClusterEvent{
+			newClusterEvent("p-1", "old", now.Add(-3*time.Hour)),
+			newClusterEvent("p-1", "new", now),
+			newClusterEvent("p-2", "old", now.Add(-2*time.Hour)),
+			foreign,
+		},
+	}
+	debouncer := utils.NewDebouncer(time.Hour)
+	debouncer.Written("p-1/old")
+
+	c := &Collector{
+		clusterName:         "c-1",
+		clusterEvents:       clusterEvents,
+		clusterEventsClient: clusterEvents.client(),
+		maxAge:              time.Hour,
+		debouncer:           debouncer,
+	}
+	if err := c.collectExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := map[string]bool{}
+	for _, key := range clusterEvents.deleted {
+		deleted[key] = true
+	}
+	if want := map[string]bool{"p-1/old": true, "p-2/old": true}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("got deleted %v, want %v", clusterEvents.deleted, want)
+	}
+	if after, _ := debouncer.Wait("p-1/old"); after != 0 {
+		t.Error("the debouncer still holds a collected cluster event")
+	}
+}
@@ -0,0 +1,88 @@
+package eventssyncer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	configReloadInterval = 30 * time.Second
+)
+
+// configLoader polls the agent ConfigMap, and hands every key to its handler when the value changes.
+// A missing ConfigMap or key is handed over as an empty value
+type configLoader struct {
+	sync.Mutex
+	configMaps typedcorev1.ConfigMapInterface
+	name       string
+	data       map[string]string
+	handlers   map[string]func(string) error
+}
+
+func newConfigLoader(configMaps typedcorev1.ConfigMapsGetter, namespacedName string) (*configLoader, error) {
+	parts := strings.SplitN(namespacedName, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid config map [%s], expected <namespace>/<name>", namespacedName)
+	}
+	return &configLoader{
+		configMaps: configMaps.ConfigMaps(parts[0]),
+		name:       parts[1],
+		handlers:   map[string]func(string) error{},
+	}, nil
+}
+
+func (c *configLoader) addHandler(key string, handler func(string) error) {
+	c.Lock()
+	defer c.Unlock()
+	c.handlers[key] = handler
+}
+
+func (c *configLoader) watch(ctx context.Context, interval time.Duration) {
+	c.reload()
+	for range utils.TickerContext(ctx, interval) {
+		c.reload()
+	}
+}
+
+func (c *configLoader) reload() {
+	data := map[string]string{}
+	configMap, err := c.configMaps.Get(c.name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Infof("Failed to read agent config map [%s]: %v", c.name, err)
+		return
+	}
+	if err == nil {
+		data = configMap.Data
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for key, handler := range c.handlers {
+		value := data[key]
+		if old, ok := c.data[key]; ok && old == value {
+			continue
+		}
+		if err := handler(value); err != nil {
+			logrus.Errorf("Invalid [%s] in agent config map [%s]: %v", key, c.name, err)
+			// keep the last valid config, and retry when the value changes
+		}
+	}
+	c.data = data
+}
+
+func unmarshalConfig(value string, into interface{}) error {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return yaml.Unmarshal([]byte(value), into)
+}
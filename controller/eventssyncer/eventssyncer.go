@@ -1,9 +1,13 @@
 package eventssyncer
 
 import (
+	"context"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
 	"github.com/rancher/types/apis/core/v1"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/rancher/types/config"
@@ -15,38 +19,183 @@ import (
 
 const (
 	projectIDLabel = "field.cattle.io/projectId"
+	// clusterEventOwnerLabel marks the ClusterEvents created by the agent of a cluster
+	clusterEventOwnerLabel = "eventssyncer.cattle.io/cluster-name"
+	// unassignedReasonAnnotation is set on ClusterEvents routed to the cluster namespace
+	// because their source namespace is not in a project
+	unassignedReasonAnnotation = "eventssyncer.cattle.io/unassigned-reason"
+
+	// UnassignedPolicyCluster routes the events of namespaces outside of any project to the cluster namespace
+	UnassignedPolicyCluster = "cluster"
+	// UnassignedPolicyDrop drops the events of namespaces outside of any project
+	UnassignedPolicyDrop = "drop"
+
+	unassignedNoProject        = "NoProject"
+	unassignedNamespaceDeleted = "NamespaceDeleted"
 )
 
+type Options struct {
+	// EventUpdateInterval is the minimum time between two updates of a ClusterEvent
+	// for an event that keeps repeating
+	EventUpdateInterval time.Duration
+	// EventTTL is how long a ClusterEvent is kept after the event was last seen. Zero keeps them forever
+	EventTTL time.Duration
+	// MaxEventsPerNamespace is the maximum number of ClusterEvents kept in a project namespace. Zero means no limit
+	MaxEventsPerNamespace int
+	// ConfigMap is the <namespace>/<name> of the agent ConfigMap holding the event filter rules, sinks and alert rules
+	ConfigMap string
+	// UnassignedPolicy is what happens to the events of namespaces not in a project, or already deleted:
+	// UnassignedPolicyCluster or UnassignedPolicyDrop
+	UnassignedPolicy string
+	// EventRateLimit is the number of events per second propagated to management, EventBurst
+	// how many can go at once. Zero disables the limit
+	EventRateLimit float64
+	EventBurst     int
+	// ObjectEventRateLimit and ObjectEventBurst are the same limits for the events of one object
+	ObjectEventRateLimit float64
+	ObjectEventBurst     int
+	// SpoolSize is the number of ClusterEvents kept while management is unavailable. Zero disables the spool
+	SpoolSize int
+}
+
 type EventsSyncer struct {
 	clusterName          string
 	clusters             v3.ClusterLister
+	clustersClient       v3.ClusterInterface
+	projects             v3.ProjectLister
+	projectsClient       v3.ProjectInterface
 	clusterEvents        v3.ClusterEventLister
 	clusterEventsClient  v3.ClusterEventInterface
 	clusterNamespaces    v1.NamespaceLister
 	managementNamespaces v1.NamespaceLister
+	events               v1.EventController
+	debouncer            *utils.Debouncer
+	filter               *Filter
+	sinks                *Sinks
+	forwardedLock        sync.Mutex
+	forwarded            map[string]string
+	unassignedPolicy     string
+	rateLimiter          *RateLimiter
+	alerts               *Alerts
+	spool                *Spool
 }
 
-func Register(workload *config.ClusterContext) {
+func Register(ctx context.Context, workload *config.ClusterContext, opts Options) error {
+	if opts.UnassignedPolicy != UnassignedPolicyCluster && opts.UnassignedPolicy != UnassignedPolicyDrop {
+		return errors.Errorf("invalid unassigned event policy [%s], expected %s or %s", opts.UnassignedPolicy, UnassignedPolicyCluster, UnassignedPolicyDrop)
+	}
+
 	e := &EventsSyncer{
 		clusterName:          workload.ClusterName,
 		clusters:             workload.Management.Management.Clusters("").Controller().Lister(),
+		clustersClient:       workload.Management.Management.Clusters(""),
+		projects:             workload.Management.Management.Projects("").Controller().Lister(),
+		projectsClient:       workload.Management.Management.Projects(""),
 		clusterEventsClient:  workload.Management.Management.ClusterEvents(""),
 		clusterNamespaces:    workload.Core.Namespaces("").Controller().Lister(),
 		managementNamespaces: workload.Management.Core.Namespaces("").Controller().Lister(),
 		clusterEvents:        workload.Management.Management.ClusterEvents("").Controller().Lister(),
+		events:               workload.Core.Events("").Controller(),
+		debouncer:            utils.NewDebouncer(opts.EventUpdateInterval),
+		filter:               &Filter{},
+		sinks:                &Sinks{},
+		forwarded:            map[string]string{},
+		unassignedPolicy:     opts.UnassignedPolicy,
+	}
+	e.spool = NewSpool(opts.SpoolSize, e.clusterEventsClient.Create)
+	if e.spool != nil {
+		go e.spool.replay(ctx, spoolReplayInterval)
+	}
+	e.rateLimiter = NewRateLimiter(opts.EventRateLimit, opts.EventBurst, opts.ObjectEventRateLimit, opts.ObjectEventBurst, e.createGeneratedClusterEvent)
+	if e.rateLimiter != nil {
+		go e.rateLimiter.summarize(ctx, summaryInterval)
 	}
 	workload.Core.Events("").Controller().AddHandler("events-syncer", e.sync)
+
+	if opts.ConfigMap != "" {
+		loader, err := newConfigLoader(workload.K8sClient.CoreV1(), opts.ConfigMap)
+		if err != nil {
+			return err
+		}
+		loader.addHandler(filterConfigKey, e.filter.load)
+		loader.addHandler(sinksConfigKey, e.sinks.load)
+		e.alerts = newAlerts(e.getEventProjectID, e.raiseAlert)
+		loader.addHandler(alertsConfigKey, e.alerts.load)
+		go loader.watch(ctx, configReloadInterval)
+		go e.alerts.evaluate(ctx, alertEvaluateInterval)
+	}
+
+	if opts.EventTTL > 0 || opts.MaxEventsPerNamespace > 0 {
+		c := &Collector{
+			clusterName:         workload.ClusterName,
+			clusterEvents:       workload.Management.Management.ClusterEvents("").Controller().Lister(),
+			clusterEventsClient: workload.Management.Management.ClusterEvents(""),
+			maxAge:              opts.EventTTL,
+			maxCount:            opts.MaxEventsPerNamespace,
+			debouncer:           e.debouncer,
+		}
+		go c.collect(ctx, collectInterval)
+	}
+	return nil
 }
 
 func (e *EventsSyncer) sync(key string, event *corev1.Event) error {
 	if event == nil {
+		e.forgetForwarded(key)
 		return nil
 	}
-	return e.createClusterEvent(key, event)
+	// alert rules see every event, filtered or not
+	e.alerts.observe(event)
+	// filter before any lookup, most events are dropped here
+	include, sinks := e.filter.evaluate(event)
+	if len(sinks) > 0 {
+		e.forward(key, sinks, event)
+	}
+	// the events the agent records are about its own actions, they are already written to management
+	// for management objects and mirroring the ones of downstream objects would double them
+	if !include || event.Source.Component == utils.RecorderComponent || !e.rateLimiter.Allow(event) {
+		return nil
+	}
+	return e.syncClusterEvent(key, event)
 }
 
-func (e *EventsSyncer) createClusterEvent(key string, event *corev1.Event) error {
-	ns, err := e.getEventNamespaceName(event)
+// forward sends the event to the sinks, once per change of the event
+func (e *EventsSyncer) forward(key string, sinks []string, event *corev1.Event) {
+	e.forwardedLock.Lock()
+	if e.forwarded[key] == event.ResourceVersion {
+		e.forwardedLock.Unlock()
+		return
+	}
+	e.forwarded[key] = event.ResourceVersion
+	e.forwardedLock.Unlock()
+
+	e.sinks.send(sinks, &EventRecord{
+		ClusterName: e.clusterName,
+		ProjectID:   e.getEventProjectID(event),
+		Event:       event,
+	})
+}
+
+func (e *EventsSyncer) forgetForwarded(key string) {
+	e.forwardedLock.Lock()
+	defer e.forwardedLock.Unlock()
+	delete(e.forwarded, key)
+}
+
+// getEventProjectID returns the <cluster name>:<project name> of the event namespace, if it belongs to a project
+func (e *EventsSyncer) getEventProjectID(event *corev1.Event) string {
+	if event.InvolvedObject.Namespace == "" {
+		return ""
+	}
+	namespace, err := e.clusterNamespaces.Get("", event.InvolvedObject.Namespace)
+	if err != nil {
+		return ""
+	}
+	return namespace.Annotations[projectIDLabel]
+}
+
+func (e *EventsSyncer) syncClusterEvent(key string, event *corev1.Event) error {
+	ns, unassignedReason, err := e.getEventNamespace(event)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			logrus.Warnf("Error propagating event [%s]: %v", event.Message, err)
@@ -57,32 +206,56 @@ func (e *EventsSyncer) createClusterEvent(key string, event *corev1.Event) error
 	if ns == nil || ns.DeletionTimestamp != nil {
 		return nil
 	}
-	existing, err := e.clusterEvents.Get(ns.Name, event.Name)
-	if err == nil || apierrors.IsNotFound(err) {
-		if existing != nil && existing.Name != "" {
-			return nil
-		}
-		cluster, err := e.clusters.Get("", e.clusterName)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				return nil
-			}
-			return errors.Wrapf(err, "Failed to get cluster [%s]", e.clusterName)
-		}
-		if cluster.DeletionTimestamp != nil {
+	existing, err := e.getExistingClusterEvent(ns.Name, event)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return e.updateClusterEvent(existing, event)
+	}
+
+	cluster, err := e.clusters.Get("", e.clusterName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
 			return nil
 		}
+		return errors.Wrapf(err, "Failed to get cluster [%s]", e.clusterName)
+	}
+	if cluster.DeletionTimestamp != nil {
+		return nil
+	}
 
-		logrus.Debugf("Creating cluster event [%s]", event.Message)
-		clusterEvent := e.convertEventToClusterEvent(event, ns)
-		_, err = e.clusterEventsClient.Create(clusterEvent)
+	logrus.Debugf("Creating cluster event [%s]", event.Message)
+	clusterEvent := e.convertEventToClusterEvent(event, ns, unassignedReason)
+	return e.createClusterEvent(clusterEvent)
+}
+
+// createClusterEvent creates the ClusterEvent, through the spool when enabled
+func (e *EventsSyncer) createClusterEvent(clusterEvent *v3.ClusterEvent) error {
+	if e.spool == nil {
+		_, err := e.clusterEventsClient.Create(clusterEvent)
 		return err
 	}
+	return e.spool.Create(clusterEvent)
+}
 
-	return err
+// createGeneratedClusterEvent writes an event generated by the agent, like the summary of suppressed events
+// or an alert, in the namespace the events it describes would have gone to
+func (e *EventsSyncer) createGeneratedClusterEvent(event *corev1.Event) error {
+	ns, unassignedReason, err := e.getEventNamespace(event)
+	if err != nil {
+		return err
+	}
+	if ns == nil || ns.DeletionTimestamp != nil {
+		return nil
+	}
+	clusterEvent := e.convertEventToClusterEvent(event, ns, unassignedReason)
+	clusterEvent.Name = ""
+	clusterEvent.GenerateName = event.GenerateName
+	return e.createClusterEvent(clusterEvent)
 }
 
-func (e *EventsSyncer) convertEventToClusterEvent(event *corev1.Event, ns *corev1.Namespace) *v3.ClusterEvent {
+func (e *EventsSyncer) convertEventToClusterEvent(event *corev1.Event, ns *corev1.Namespace, unassignedReason string) *v3.ClusterEvent {
 	clusterEvent := &v3.ClusterEvent{
 		Event: *event,
 	}
@@ -90,30 +263,47 @@ func (e *EventsSyncer) convertEventToClusterEvent(event *corev1.Event, ns *corev
 	clusterEvent.Kind = "ClusterEvent"
 	clusterEvent.ClusterName = e.clusterName
 	clusterEvent.ObjectMeta = metav1.ObjectMeta{
-		Name:        event.Name,
-		Labels:      event.Labels,
+		Name:        getClusterEventName(event),
+		Labels:      map[string]string{},
 		Annotations: event.Annotations,
 		Namespace:   ns.Name,
 	}
+	for key, value := range event.Labels {
+		clusterEvent.Labels[key] = value
+	}
+	clusterEvent.Labels[clusterEventOwnerLabel] = e.clusterName
+	clusterEvent.Labels[sourceNamespaceLabel] = event.Namespace
+	if unassignedReason != "" {
+		clusterEvent.Annotations = map[string]string{}
+		for key, value := range event.Annotations {
+			clusterEvent.Annotations[key] = value
+		}
+		clusterEvent.Annotations[unassignedReasonAnnotation] = unassignedReason
+	}
 	return clusterEvent
 }
 
-func (e *EventsSyncer) getEventNamespaceName(event *corev1.Event) (*corev1.Namespace, error) {
+// getEventNamespace returns the management namespace the event goes to. When it is routed to the
+// cluster namespace because its namespace isn't in a project, the reason is returned as well
+func (e *EventsSyncer) getEventNamespace(event *corev1.Event) (*corev1.Namespace, string, error) {
 	involedObjectNamespace := event.InvolvedObject.Namespace
 	if involedObjectNamespace == "" {
 		// cluster namespace, equals to cluster.name
 		namespace, err := e.managementNamespaces.Get("", e.clusterName)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		return namespace, nil
+		return namespace, "", nil
 	}
 
 	// user namespace, derive from the project id
 	// field.cattle.io/projectId value is <cluster name>:<project name>
 	userNamespace, err := e.clusterNamespaces.Get("", involedObjectNamespace)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to find user namespace [%s]", e.clusterName)
+		if apierrors.IsNotFound(err) {
+			return e.getUnassignedNamespace(unassignedNamespaceDeleted)
+		}
+		return nil, "", errors.Wrapf(err, "Failed to find user namespace [%s]", involedObjectNamespace)
 	}
 	if userNamespace.Annotations[projectIDLabel] != "" {
 		parts := strings.Split(userNamespace.Annotations[projectIDLabel], ":")
@@ -122,10 +312,62 @@ func (e *EventsSyncer) getEventNamespaceName(event *corev1.Event) (*corev1.Names
 			projectNamespaceName := parts[1]
 			namespace, err := e.managementNamespaces.Get("", projectNamespaceName)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
-			return namespace, nil
+			return namespace, "", nil
+		}
+	}
+	return e.getUnassignedNamespace(unassignedNoProject)
+}
+
+func (e *EventsSyncer) getUnassignedNamespace(reason string) (*corev1.Namespace, string, error) {
+	if e.unassignedPolicy == UnassignedPolicyDrop {
+		return nil, "", nil
+	}
+	namespace, err := e.managementNamespaces.Get("", e.clusterName)
+	if err != nil {
+		return nil, "", err
+	}
+	return namespace, reason, nil
+}
+
+func (e *EventsSyncer) updateClusterEvent(existing *v3.ClusterEvent, event *corev1.Event) error {
+	if existing.Count == event.Count &&
+		existing.LastTimestamp.Equal(&event.LastTimestamp) &&
+		existing.Message == event.Message &&
+		existing.Labels[clusterEventOwnerLabel] == e.clusterName &&
+		existing.Labels[sourceNamespaceLabel] == event.Namespace {
+		return nil
+	}
+
+	key := existing.Namespace + "/" + existing.Name
+	if after, scheduled := e.debouncer.Wait(key); after > 0 {
+		// the event is synced again once the interval is over, and the latest count gets written then
+		if scheduled {
+			time.AfterFunc(after, func() {
+				e.events.Enqueue(event.Namespace, event.Name)
+			})
 		}
+		return nil
+	}
+
+	toUpdate := existing.DeepCopy()
+	toUpdate.Count = event.Count
+	toUpdate.FirstTimestamp = event.FirstTimestamp
+	toUpdate.LastTimestamp = event.LastTimestamp
+	toUpdate.Message = event.Message
+	toUpdate.Reason = event.Reason
+	toUpdate.Type = event.Type
+	// adopt events created before the labels were introduced, so they get collected
+	if toUpdate.Labels == nil {
+		toUpdate.Labels = map[string]string{}
+	}
+	toUpdate.Labels[clusterEventOwnerLabel] = e.clusterName
+	toUpdate.Labels[sourceNamespaceLabel] = event.Namespace
+	logrus.Debugf("Updating cluster event [%s]", event.Message)
+	if _, err := e.clusterEventsClient.Update(toUpdate); err != nil {
+		return errors.Wrapf(err, "Failed to update cluster event [%s]", existing.Name)
 	}
-	return nil, nil
+	e.debouncer.Written(key)
+	return nil
 }
@@ -0,0 +1,54 @@
+package eventssyncer
+
+import (
+	"testing"
+
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeClusters struct {
+	v3.ClusterLister
+}
+
+func (f *fakeClusters) Get(namespace, name string) (*v3.Cluster, error) {
+	return &v3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func TestSyncSkipsAgentEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		component   string
+		wantCreated bool
+	}{
+		{name: "kubelet", component: "kubelet", wantCreated: true},
+		{name: "agent", component: utils.RecorderComponent},
+	}
+
+	for _, test := range tests {
+		clusterEvents := &fakeClusterEvents{}
+		e := &EventsSyncer{
+			clusterName:          "c-1",
+			clusters:             &fakeClusters{},
+			clusterEvents:        clusterEvents,
+			clusterEventsClient:  clusterEvents.client(),
+			clusterNamespaces:    newFakeNamespaces(newNamespace("default", "c-1:p-1")),
+			managementNamespaces: newFakeNamespaces(newNamespace("c-1", ""), newNamespace("p-1", "")),
+			filter:               &Filter{},
+			sinks:                &Sinks{},
+			forwarded:            map[string]string{},
+			unassignedPolicy:     UnassignedPolicyCluster,
+		}
+		event := newEvent("default", "pod-1.123", "uid-1")
+		event.Source.Component = test.component
+
+		if err := e.sync("default/pod-1.123", event); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if created := len(clusterEvents.created) == 1; created != test.wantCreated {
+			t.Errorf("%s: got cluster event created %v, want %v", test.name, created, test.wantCreated)
+		}
+	}
+}
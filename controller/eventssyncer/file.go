@@ -0,0 +1,139 @@
+package eventssyncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultFileMaxSizeMB  = 100
+	defaultFileMaxBackups = 3
+)
+
+// FileConfig appends every record as a json line to a file, rotated once it reaches MaxSizeMB.
+// Rotated files are named <path>.1 up to <path>.<MaxBackups>, .1 being the most recent
+type FileConfig struct {
+	Path       string `json:"path"`
+	MaxSizeMB  int    `json:"maxSizeMB,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty"`
+}
+
+type fileSink struct {
+	*queue
+	config *FileConfig
+	file   *os.File
+	size   int64
+}
+
+func newFileSink(name string, config *FileConfig) (Sink, error) {
+	if config.Path == "" {
+		return nil, errors.Errorf("file sink [%s] has no path", name)
+	}
+	// defaults are set on a copy, the config is compared on reload
+	copied := *config
+	config = &copied
+	if config.MaxSizeMB <= 0 {
+		config.MaxSizeMB = defaultFileMaxSizeMB
+	}
+	if config.MaxBackups <= 0 {
+		config.MaxBackups = defaultFileMaxBackups
+	}
+
+	f := &fileSink{
+		queue:  newQueue(name),
+		config: config,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.run()
+	return f, nil
+}
+
+func (f *fileSink) Close() {
+	f.close()
+}
+
+func (f *fileSink) run() {
+	defer close(f.done)
+	defer func() {
+		if f.file != nil {
+			f.file.Close()
+		}
+	}()
+
+	for record := range f.records {
+		if err := f.write(record); err != nil {
+			sinkRecords.Add(f.name+".failed", 1)
+			logrus.Warnf("Failed to write event to file sink [%s]: %v", f.name, err)
+			continue
+		}
+		sinkRecords.Add(f.name+".sent", 1)
+	}
+}
+
+func (f *fileSink) write(record *EventRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size+int64(len(line)) > int64(f.config.MaxSizeMB)*1024*1024 {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return err
+			}
+			// keep appending to the current file, rotating is tried again with the next record
+			logrus.Warnf("Failed to rotate file sink [%s]: %v", f.name, err)
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *fileSink) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file sink [%s]", f.name)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the file to the first backup and opens a new one. When the file can't be moved,
+// it is opened again so the records still get written
+func (f *fileSink) rotate() error {
+	f.file.Close()
+	f.file = nil
+	for i := f.config.MaxBackups - 1; i > 0; i-- {
+		os.Rename(backupPath(f.config.Path, i), backupPath(f.config.Path, i+1))
+	}
+	if err := os.Rename(f.config.Path, backupPath(f.config.Path, 1)); err != nil {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return f.open()
+}
+
+func backupPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
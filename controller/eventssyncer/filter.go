@@ -0,0 +1,106 @@
+package eventssyncer
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/norman/types/slice"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	filterConfigKey = "event-filters"
+
+	filterActionInclude = "include"
+	filterActionExclude = "exclude"
+	// defaultFilterRule is the counter of events no rule matched, they are included
+	defaultFilterRule = "default"
+)
+
+var (
+	// filterMatches counts the events matched by each filter rule
+	filterMatches = expvar.NewMap("eventssyncer_filter_matches")
+)
+
+// FilterRule includes or excludes the events matching all of its non empty criteria
+type FilterRule struct {
+	Name       string   `json:"name"`
+	Action     string   `json:"action"`
+	Types      []string `json:"types,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Components []string `json:"components,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Sinks the matching events are forwarded to, whether they are included or not
+	Sinks []string `json:"sinks,omitempty"`
+}
+
+type filterConfig struct {
+	Rules []FilterRule `json:"rules"`
+}
+
+// Filter decides which events are propagated. Rules are evaluated in order and the first
+// matching rule wins, events no rule matches are propagated
+type Filter struct {
+	sync.RWMutex
+	rules []FilterRule
+}
+
+func (f *Filter) load(value string) error {
+	config := &filterConfig{}
+	if err := unmarshalConfig(value, config); err != nil {
+		return err
+	}
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			return errors.Errorf("event filter rule %d has no name", i)
+		}
+		if rule.Action != filterActionInclude && rule.Action != filterActionExclude {
+			return errors.Errorf("event filter rule [%s] has invalid action [%s]", rule.Name, rule.Action)
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.rules = config.Rules
+	return nil
+}
+
+// match returns the first rule matching the event, nil if none does
+func (f *Filter) match(event *corev1.Event) *FilterRule {
+	if f == nil {
+		return nil
+	}
+	f.RLock()
+	defer f.RUnlock()
+	for i := range f.rules {
+		if f.rules[i].matches(event) {
+			return &f.rules[i]
+		}
+	}
+	return nil
+}
+
+// evaluate returns whether the event is propagated to management, and the sinks it is forwarded to
+func (f *Filter) evaluate(event *corev1.Event) (bool, []string) {
+	rule := f.match(event)
+	if rule == nil {
+		filterMatches.Add(defaultFilterRule, 1)
+		return true, nil
+	}
+	filterMatches.Add(rule.Name, 1)
+	return rule.Action == filterActionInclude, rule.Sinks
+}
+
+func (r *FilterRule) matches(event *corev1.Event) bool {
+	return matchesAny(r.Types, event.Type) &&
+		matchesAny(r.Reasons, event.Reason) &&
+		matchesAny(r.Components, event.Source.Component) &&
+		matchesAny(r.Kinds, event.InvolvedObject.Kind) &&
+		matchesAny(r.Namespaces, event.InvolvedObject.Namespace)
+}
+
+func matchesAny(values []string, value string) bool {
+	return len(values) == 0 || slice.ContainsString(values, value)
+}
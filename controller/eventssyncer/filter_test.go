@@ -0,0 +1,127 @@
+package eventssyncer
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const filterRules = `
+rules:
+- name: drop-normal-pulls
+  action: exclude
+  types: [Normal]
+  reasons: [Pulling, Pulled]
+- name: forward-kube-system
+  action: include
+  namespaces: [kube-system]
+  sinks: [audit]
+- name: drop-kube-system
+  action: exclude
+  namespaces: [kube-system]
+- name: drop-scheduler
+  action: exclude
+  components: [default-scheduler]
+  kinds: [Pod]
+`
+
+func TestFilterEvaluate(t *testing.T) {
+	f := &Filter{}
+	if err := f.load(filterRules); err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(eventType, reason, component, kind, namespace string) *corev1.Event {
+		return &corev1.Event{
+			Type:           eventType,
+			Reason:         reason,
+			Source:         corev1.EventSource{Component: component},
+			InvolvedObject: corev1.ObjectReference{Kind: kind, Namespace: namespace},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		event       *corev1.Event
+		wantInclude bool
+		wantSinks   []string
+	}{
+		{
+			name:        "no rule matches",
+			event:       event("Warning", "BackOff", "kubelet", "Pod", "default"),
+			wantInclude: true,
+		},
+		{
+			name:  "all criteria match",
+			event: event("Normal", "Pulled", "kubelet", "Pod", "default"),
+		},
+		{
+			name:        "one criteria doesn't match",
+			event:       event("Warning", "Pulled", "kubelet", "Pod", "default"),
+			wantInclude: true,
+		},
+		{
+			name:        "first matching rule wins",
+			event:       event("Warning", "BackOff", "kubelet", "Pod", "kube-system"),
+			wantInclude: true,
+			wantSinks:   []string{"audit"},
+		},
+		{
+			name:  "earlier rule wins over a later one",
+			event: event("Normal", "Pulling", "kubelet", "Pod", "kube-system"),
+		},
+		{
+			name:  "component and kind",
+			event: event("Normal", "Scheduled", "default-scheduler", "Pod", "default"),
+		},
+		{
+			name:        "component of another kind",
+			event:       event("Normal", "Scheduled", "default-scheduler", "Node", ""),
+			wantInclude: true,
+		},
+	}
+
+	for _, test := range tests {
+		include, sinks := f.evaluate(test.event)
+		if include != test.wantInclude || !reflect.DeepEqual(sinks, test.wantSinks) {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, include, sinks, test.wantInclude, test.wantSinks)
+		}
+	}
+}
+
+func TestFilterLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "valid", value: filterRules},
+		{name: "no name", value: "rules: [{action: include}]", wantErr: true},
+		{name: "invalid action", value: "rules: [{name: a, action: drop}]", wantErr: true},
+		{name: "invalid yaml", value: "rules: [", wantErr: true},
+	}
+
+	for _, test := range tests {
+		f := &Filter{}
+		if err := f.load(filterRules); err != nil {
+			t.Fatal(err)
+		}
+		err := f.load(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		// an invalid config keeps the last valid rules
+		if test.wantErr && len(f.rules) == 0 {
+			t.Errorf("%s: the last valid rules are dropped", test.name)
+		}
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	var f *Filter
+	if include, sinks := f.evaluate(&corev1.Event{}); !include || sinks != nil {
+		t.Errorf("got %v, %v, want every event included", include, sinks)
+	}
+}
@@ -0,0 +1,57 @@
+package eventssyncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// sourceNamespaceLabel records the namespace of the event a ClusterEvent was created from
+	sourceNamespaceLabel = "eventssyncer.cattle.io/source-namespace"
+	// maxNameLength is the maximum length of an object name
+	maxNameLength  = 253
+	nameHashLength = 10
+)
+
+// getClusterEventName derives the ClusterEvent name from the source event namespace, name and UID.
+// Events with the same name in two namespaces of a project don't collide in the project namespace
+func getClusterEventName(event *corev1.Event) string {
+	hash := sha256.Sum256([]byte(event.Namespace + "/" + event.Name + "/" + string(event.UID)))
+	suffix := hex.EncodeToString(hash[:])[:nameHashLength]
+	name := event.Name
+	if len(name) > maxNameLength-nameHashLength-1 {
+		name = name[:maxNameLength-nameHashLength-1]
+	}
+	return name + "-" + suffix
+}
+
+// getExistingClusterEvent finds the ClusterEvent created for the event, if any. ClusterEvents created
+// before names were derived from the source are named after the event, and are recognized by their
+// involved object
+func (e *EventsSyncer) getExistingClusterEvent(namespace string, event *corev1.Event) (*v3.ClusterEvent, error) {
+	existing, err := e.clusterEvents.Get(namespace, getClusterEventName(event))
+	if err == nil {
+		return existing, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	legacy, err := e.clusterEvents.Get(namespace, event.Name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if legacy.InvolvedObject.Namespace == event.InvolvedObject.Namespace &&
+		legacy.InvolvedObject.Name == event.InvolvedObject.Name &&
+		legacy.InvolvedObject.UID == event.InvolvedObject.UID {
+		return legacy, nil
+	}
+	return nil, nil
+}
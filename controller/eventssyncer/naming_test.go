@@ -0,0 +1,97 @@
+package eventssyncer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newEvent(namespace, name string, uid types.UID) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       uid,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+}
+
+func TestGetClusterEventName(t *testing.T) {
+	name := getClusterEventName(newEvent("ns-1", "pod-1.123", "uid-1"))
+	if !strings.HasPrefix(name, "pod-1.123-") || len(name) != len("pod-1.123-")+nameHashLength {
+		t.Errorf("got %q, want the event name and a %d characters hash", name, nameHashLength)
+	}
+	if again := getClusterEventName(newEvent("ns-1", "pod-1.123", "uid-1")); again != name {
+		t.Errorf("got %q then %q for the same event", name, again)
+	}
+	if other := getClusterEventName(newEvent("ns-2", "pod-1.123", "uid-1")); other == name {
+		t.Errorf("got %q for the same event name in two namespaces", name)
+	}
+	if other := getClusterEventName(newEvent("ns-1", "pod-1.123", "uid-2")); other == name {
+		t.Errorf("got %q for a recreated event", name)
+	}
+
+	long := getClusterEventName(newEvent("ns-1", strings.Repeat("a", 300), "uid-1"))
+	if len(long) != maxNameLength {
+		t.Errorf("got a name of %d characters for a long event name, want %d", len(long), maxNameLength)
+	}
+}
+
+func TestGetExistingClusterEvent(t *testing.T) {
+	event := newEvent("ns-1", "pod-1.123", "uid-1")
+
+	current := newClusterEvent("p-1", getClusterEventName(event), metav1.Now().Time)
+	legacy := newClusterEvent("p-1", event.Name, metav1.Now().Time)
+	legacy.InvolvedObject = event.InvolvedObject
+	otherObject := newClusterEvent("p-1", event.Name, metav1.Now().Time)
+	otherObject.InvolvedObject = event.InvolvedObject
+	otherObject.InvolvedObject.UID = "other-pod-uid"
+
+	tests := []struct {
+		name          string
+		clusterEvents []*v3.ClusterEvent
+		want          *v3.ClusterEvent
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:          "named after the source",
+			clusterEvents: []*v3.ClusterEvent{legacy, current},
+			want:          current,
+		},
+		{
+			name:          "legacy name",
+			clusterEvents: []*v3.ClusterEvent{legacy},
+			want:          legacy,
+		},
+		{
+			name:          "legacy name of another object",
+			clusterEvents: []*v3.ClusterEvent{otherObject},
+		},
+	}
+
+	for _, test := range tests {
+		e := &EventsSyncer{
+			clusterEvents: &fakeClusterEvents{clusterEvents: test.clusterEvents},
+		}
+		got, err := e.getExistingClusterEvent("p-1", event)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package eventssyncer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// summaryInterval is how often the suppressed events are written as summary ClusterEvents
+	summaryInterval = time.Minute
+	// maxSuppressedGroups bounds the summaries written per interval, the rest is only counted
+	maxSuppressedGroups = 100
+	suppressedReason    = "EventsSuppressed"
+)
+
+var (
+	// rateLimited counts the events suppressed by the object and global limits, and the summaries written
+	rateLimited = expvar.NewMap("eventssyncer_rate_limited")
+)
+
+// RateLimiter throttles the events propagated to management, with a token bucket per involved
+// object and a global one. Suppressed events are grouped by namespace, reason and type, and each
+// group gets written as one summary event per interval
+type RateLimiter struct {
+	sync.Mutex
+	global       *ratelimit.Bucket
+	objectRate   float64
+	objectBurst  int64
+	objects      map[string]*ratelimit.Bucket
+	suppressed   map[string]*suppressedGroup
+	overflow     int
+	writeSummary func(event *corev1.Event) error
+}
+
+type suppressedGroup struct {
+	sample *corev1.Event
+	count  int
+}
+
+// NewRateLimiter returns nil when both limits are disabled, a nil RateLimiter allows every event
+func NewRateLimiter(globalRate float64, globalBurst int, objectRate float64, objectBurst int, writeSummary func(event *corev1.Event) error) *RateLimiter {
+	if globalRate <= 0 && objectRate <= 0 {
+		return nil
+	}
+	r := &RateLimiter{
+		objectRate:   objectRate,
+		objectBurst:  int64(maxInt(objectBurst, 1)),
+		objects:      map[string]*ratelimit.Bucket{},
+		suppressed:   map[string]*suppressedGroup{},
+		writeSummary: writeSummary,
+	}
+	if globalRate > 0 {
+		r.global = ratelimit.NewBucketWithRate(globalRate, int64(maxInt(globalBurst, 1)))
+	}
+	return r
+}
+
+// Allow takes a token for the event, or records it as suppressed
+func (r *RateLimiter) Allow(event *corev1.Event) bool {
+	if r == nil {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	if r.objectRate > 0 {
+		key := involvedObjectKey(event)
+		bucket, ok := r.objects[key]
+		if !ok {
+			bucket = ratelimit.NewBucketWithRate(r.objectRate, r.objectBurst)
+			r.objects[key] = bucket
+		}
+		if bucket.TakeAvailable(1) == 0 {
+			rateLimited.Add("object", 1)
+			r.suppress(event)
+			return false
+		}
+	}
+	if r.global != nil && r.global.TakeAvailable(1) == 0 {
+		rateLimited.Add("global", 1)
+		r.suppress(event)
+		return false
+	}
+	return true
+}
+
+func (r *RateLimiter) suppress(event *corev1.Event) {
+	key := fmt.Sprintf("%s/%s/%s", event.InvolvedObject.Namespace, event.Reason, event.Type)
+	group, ok := r.suppressed[key]
+	if !ok {
+		if len(r.suppressed) >= maxSuppressedGroups {
+			r.overflow++
+			return
+		}
+		group = &suppressedGroup{}
+		r.suppressed[key] = group
+	}
+	group.sample = event
+	group.count++
+}
+
+func (r *RateLimiter) summarize(ctx context.Context, interval time.Duration) {
+	for range utils.TickerContext(ctx, interval) {
+		r.flush()
+	}
+}
+
+// flush writes a summary event per group suppressed since the last flush, and drops the
+// buckets of the objects that have been quiet long enough to be full again
+func (r *RateLimiter) flush() {
+	r.Lock()
+	suppressed, overflow := r.suppressed, r.overflow
+	r.suppressed = map[string]*suppressedGroup{}
+	r.overflow = 0
+	for key, bucket := range r.objects {
+		if bucket.Available() >= bucket.Capacity() {
+			delete(r.objects, key)
+		}
+	}
+	r.Unlock()
+
+	if overflow > 0 {
+		logrus.Warnf("Suppressed %d more events without a summary", overflow)
+	}
+	for _, group := range suppressed {
+		if err := r.writeSummary(newSummaryEvent(group)); err != nil {
+			logrus.Warnf("Failed to write summary of %d suppressed events: %v", group.count, err)
+			continue
+		}
+		rateLimited.Add("summaries", 1)
+	}
+}
+
+// newSummaryEvent describes a group of suppressed events, as an event of their namespace
+func newSummaryEvent(group *suppressedGroup) *corev1.Event {
+	now := metav1.Now()
+	sample := group.sample
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "events-suppressed-",
+			Namespace:    sample.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Namespace",
+			Name:      sample.InvolvedObject.Namespace,
+			Namespace: sample.InvolvedObject.Namespace,
+		},
+		Reason: suppressedReason,
+		Message: fmt.Sprintf("%d similar events suppressed, last %s %s: %s",
+			group.count, sample.InvolvedObject.Kind, sample.InvolvedObject.Name, sample.Message),
+		Source:         sample.Source,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          int32(group.count),
+		Type:           sample.Type,
+	}
+	if sample.InvolvedObject.Namespace == "" {
+		event.InvolvedObject = sample.InvolvedObject
+	}
+	return event
+}
+
+func involvedObjectKey(event *corev1.Event) string {
+	object := event.InvolvedObject
+	if object.UID != "" {
+		return string(object.UID)
+	}
+	return fmt.Sprintf("%s/%s/%s", object.Kind, object.Namespace, object.Name)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,152 @@
+package eventssyncer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newObjectEvent(namespace, name, reason string) *corev1.Event {
+	return &corev1.Event{
+		Reason:  reason,
+		Type:    corev1.EventTypeWarning,
+		Message: "message of " + name,
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID(namespace + "/" + name),
+		},
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	tests := []struct {
+		name        string
+		globalRate  float64
+		globalBurst int
+		objectRate  float64
+		objectBurst int
+		// events is the number of events sent per object, at once
+		events  map[string]int
+		allowed map[string]int
+	}{
+		{
+			name:        "object burst",
+			objectRate:  0.001,
+			objectBurst: 2,
+			events:      map[string]int{"pod-1": 5, "pod-2": 1},
+			allowed:     map[string]int{"pod-1": 2, "pod-2": 1},
+		},
+		{
+			name:        "global burst",
+			globalRate:  0.001,
+			globalBurst: 3,
+			events:      map[string]int{"pod-1": 2, "pod-2": 2},
+			allowed:     map[string]int{"pod-1": 2, "pod-2": 1},
+		},
+		{
+			name:        "object limit first",
+			globalRate:  0.001,
+			globalBurst: 3,
+			objectRate:  0.001,
+			objectBurst: 1,
+			events:      map[string]int{"pod-1": 3, "pod-2": 3},
+			allowed:     map[string]int{"pod-1": 1, "pod-2": 1},
+		},
+	}
+
+	for _, test := range tests {
+		r := NewRateLimiter(test.globalRate, test.globalBurst, test.objectRate, test.objectBurst, nil)
+		allowed := map[string]int{}
+		for _, name := range []string{"pod-1", "pod-2"} {
+			for i := 0; i < test.events[name]; i++ {
+				if r.Allow(newObjectEvent("default", name, "BackOff")) {
+					allowed[name]++
+				}
+			}
+		}
+		for name, want := range test.allowed {
+			if allowed[name] != want {
+				t.Errorf("%s: got %d events of [%s] allowed, want %d", test.name, allowed[name], name, want)
+			}
+		}
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	r := NewRateLimiter(0, 10, 0, 10, nil)
+	if r != nil {
+		t.Fatal("got a rate limiter with both limits disabled")
+	}
+	for i := 0; i < 100; i++ {
+		if !r.Allow(newObjectEvent("default", "pod-1", "BackOff")) {
+			t.Fatal("event suppressed without limits")
+		}
+	}
+}
+
+func TestRateLimiterSummaries(t *testing.T) {
+	var summaries []*corev1.Event
+	r := NewRateLimiter(0, 0, 0.001, 1, func(event *corev1.Event) error {
+		summaries = append(summaries, event)
+		return nil
+	})
+
+	for i := 0; i < 4; i++ {
+		r.Allow(newObjectEvent("default", "pod-1", "BackOff"))
+	}
+	r.Allow(newObjectEvent("default", "pod-2", "BackOff"))
+	r.Allow(newObjectEvent("default", "pod-2", "BackOff"))
+	r.Allow(newObjectEvent("default", "pod-2", "Failed"))
+	r.flush()
+
+	// grouped by namespace, reason and type
+	if len(summaries) != 2 {
+		t.Fatalf("got %d summaries, want 2", len(summaries))
+	}
+	// 3 BackOff of pod-1 and 1 of pod-2, then 1 Failed of pod-2
+	counts := map[int32]bool{}
+	for _, summary := range summaries {
+		counts[summary.Count] = true
+		if summary.Reason != suppressedReason || summary.InvolvedObject.Kind != "Namespace" || summary.InvolvedObject.Name != "default" {
+			t.Errorf("got summary %+v", summary)
+		}
+		if !strings.HasPrefix(summary.Message, fmt.Sprintf("%d similar events suppressed, last Pod pod-2", summary.Count)) {
+			t.Errorf("got summary message %q", summary.Message)
+		}
+	}
+	if !counts[4] || !counts[1] {
+		t.Errorf("got summaries %v, want 4 and 1 suppressed events", summaries)
+	}
+
+	// nothing suppressed since the last flush
+	summaries = nil
+	r.flush()
+	if len(summaries) != 0 {
+		t.Errorf("got %d summaries without suppressed events", len(summaries))
+	}
+}
+
+func TestRateLimiterOverflow(t *testing.T) {
+	written := 0
+	r := NewRateLimiter(0, 0, 0.001, 1, func(event *corev1.Event) error {
+		written++
+		return nil
+	})
+	for i := 0; i < maxSuppressedGroups+10; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		r.Allow(newObjectEvent(namespace, "pod-1", "BackOff"))
+		r.Allow(newObjectEvent(namespace, "pod-1", "BackOff"))
+	}
+	if r.overflow != 10 {
+		t.Errorf("got %d events over the group limit, want 10", r.overflow)
+	}
+	r.flush()
+	if written != maxSuppressedGroups {
+		t.Errorf("got %d summaries, want %d", written, maxSuppressedGroups)
+	}
+}
@@ -0,0 +1,244 @@
+package eventssyncer
+
+import (
+	"expvar"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	sinksConfigKey = "event-sinks"
+	// sinkBufferSize is the number of records a sink queues before dropping
+	sinkBufferSize = 1000
+)
+
+var (
+	// sinkRecords counts the records sent, failed and dropped per sink
+	sinkRecords = expvar.NewMap("eventssyncer_sink_records")
+)
+
+// EventRecord is what the sinks forward for an event
+type EventRecord struct {
+	ClusterName string        `json:"clusterName"`
+	ProjectID   string        `json:"projectId,omitempty"`
+	Event       *corev1.Event `json:"event"`
+}
+
+// Sink forwards event records to a destination outside of rancher
+type Sink interface {
+	// Send queues the record, it never blocks
+	Send(record *EventRecord)
+	// Close flushes the queued records and releases the sink
+	Close()
+}
+
+// SinkConfig configures one sink, exactly one of its destinations has to be set
+type SinkConfig struct {
+	Name    string         `json:"name"`
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	Syslog  *SyslogConfig  `json:"syslog,omitempty"`
+	File    *FileConfig    `json:"file,omitempty"`
+}
+
+type sinksConfig struct {
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// Sinks holds the configured sinks by name
+type Sinks struct {
+	sync.RWMutex
+	// reloadLock serializes loads, the maps are only replaced with it held
+	reloadLock sync.Mutex
+	sinks      map[string]Sink
+	configs    map[string]SinkConfig
+}
+
+// load replaces the sinks with the configured ones. Sinks whose config didn't change are kept
+// as they are. A file sink writing to the path of a sink being replaced is only opened once the
+// replaced sink is drained, so two sinks never append to the same file
+func (s *Sinks) load(value string) error {
+	config := &sinksConfig{}
+	if err := unmarshalConfig(value, config); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, sinkConfig := range config.Sinks {
+		if err := validateSinkConfig(sinkConfig); err != nil {
+			return err
+		}
+		if names[sinkConfig.Name] {
+			return errors.Errorf("event sink [%s] is configured more than once", sinkConfig.Name)
+		}
+		names[sinkConfig.Name] = true
+	}
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	replacedPaths := map[string]bool{}
+	for name, old := range s.configs {
+		if old.File != nil && !reflect.DeepEqual(old, configByName(config.Sinks, name)) {
+			replacedPaths[old.File.Path] = true
+		}
+	}
+
+	sinks := map[string]Sink{}
+	configs := map[string]SinkConfig{}
+	var deferred []SinkConfig
+	for _, sinkConfig := range config.Sinks {
+		configs[sinkConfig.Name] = sinkConfig
+		if old, ok := s.configs[sinkConfig.Name]; ok && reflect.DeepEqual(old, sinkConfig) {
+			sinks[sinkConfig.Name] = s.sinks[sinkConfig.Name]
+			continue
+		}
+		if sinkConfig.File != nil && replacedPaths[sinkConfig.File.Path] {
+			deferred = append(deferred, sinkConfig)
+			continue
+		}
+		sink, err := newSink(sinkConfig)
+		if err != nil {
+			for name, created := range sinks {
+				if created != s.sinks[name] {
+					created.Close()
+				}
+			}
+			return err
+		}
+		sinks[sinkConfig.Name] = sink
+	}
+
+	var replaced []Sink
+	for name, sink := range s.sinks {
+		if sinks[name] != sink {
+			replaced = append(replaced, sink)
+		}
+	}
+
+	// sends queue with the read lock held, once the maps are swapped no send reaches a replaced sink.
+	// Closing waits for the sinks to drain, which is done without blocking the sends
+	s.Lock()
+	s.sinks = sinks
+	s.configs = configs
+	s.Unlock()
+	for _, sink := range replaced {
+		sink.Close()
+	}
+
+	if len(deferred) == 0 {
+		return nil
+	}
+	// records for the deferred sinks are dropped until they are opened
+	var err error
+	created := map[string]Sink{}
+	for _, sinkConfig := range deferred {
+		sink, createErr := newSink(sinkConfig)
+		if createErr != nil {
+			err = createErr
+			continue
+		}
+		created[sinkConfig.Name] = sink
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, sinkConfig := range deferred {
+		if sink, ok := created[sinkConfig.Name]; ok {
+			s.sinks[sinkConfig.Name] = sink
+		} else {
+			delete(s.configs, sinkConfig.Name)
+		}
+	}
+	return err
+}
+
+// validateSinkConfig checks the sink has a name and exactly one destination
+func validateSinkConfig(config SinkConfig) error {
+	if config.Name == "" {
+		return errors.New("event sink has no name")
+	}
+	destinations := 0
+	for _, set := range []bool{config.Webhook != nil, config.Syslog != nil, config.File != nil} {
+		if set {
+			destinations++
+		}
+	}
+	switch destinations {
+	case 0:
+		return errors.Errorf("event sink [%s] has no destination", config.Name)
+	case 1:
+		return nil
+	}
+	return errors.Errorf("event sink [%s] has more than one destination", config.Name)
+}
+
+func configByName(configs []SinkConfig, name string) SinkConfig {
+	for _, config := range configs {
+		if config.Name == name {
+			return config
+		}
+	}
+	return SinkConfig{}
+}
+
+func (s *Sinks) send(names []string, record *EventRecord) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, name := range names {
+		sink, ok := s.sinks[name]
+		if !ok {
+			logrus.Debugf("Event sink [%s] is not configured", name)
+			continue
+		}
+		sink.Send(record)
+	}
+}
+
+func newSink(config SinkConfig) (Sink, error) {
+	if err := validateSinkConfig(config); err != nil {
+		return nil, err
+	}
+	switch {
+	case config.Webhook != nil:
+		return newWebhookSink(config.Name, config.Webhook)
+	case config.Syslog != nil:
+		return newSyslogSink(config.Name, config.Syslog)
+	}
+	return newFileSink(config.Name, config.File)
+}
+
+// queue is the buffered channel every sink reads its records from
+type queue struct {
+	name    string
+	records chan *EventRecord
+	done    chan struct{}
+}
+
+func newQueue(name string) *queue {
+	return &queue{
+		name:    name,
+		records: make(chan *EventRecord, sinkBufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+func (q *queue) Send(record *EventRecord) {
+	select {
+	case q.records <- record:
+	default:
+		sinkRecords.Add(q.name+".dropped", 1)
+	}
+}
+
+// close stops accepting records and waits for the sink to drain the queue
+func (q *queue) close() {
+	close(q.records)
+	select {
+	case <-q.done:
+	case <-time.After(10 * time.Second):
+		logrus.Warnf("Timed out flushing event sink [%s]", q.name)
+	}
+}
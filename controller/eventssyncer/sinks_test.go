@@ -0,0 +1,399 @@
+package eventssyncer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newRecord(message string) *EventRecord {
+	return &EventRecord{
+		ClusterName: "c-1",
+		ProjectID:   "c-1:p-1",
+		Event: &corev1.Event{
+			Type:    corev1.EventTypeWarning,
+			Reason:  "BackOff",
+			Message: message,
+			InvolvedObject: corev1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "default",
+				Name:      "pod-1",
+			},
+			Count: 2,
+		},
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]*EventRecord
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Content-Type") != "application/json" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var batch []*EventRecord
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		batches = append(batches, batch)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	sink, err := newWebhookSink("webhook", &WebhookConfig{
+		URL:           server.URL,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		BatchSize:     2,
+		FlushInterval: "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		sink.Send(newRecord(fmt.Sprintf("message %d", i)))
+	}
+	// the last partial batch is flushed on close
+	sink.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("got batches %v, want 2 and 1 records", batches)
+	}
+	if got := batches[1][0]; got.Event.Message != "message 2" || got.ProjectID != "c-1:p-1" {
+		t.Errorf("got record %+v", got)
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := newSyslogSink("syslog", &SyslogConfig{Network: "udp", Address: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Send(newRecord("Back-off restarting failed container"))
+	sink.Close()
+
+	buffer := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buffer[:n])
+	// local0.warning
+	if !strings.HasPrefix(message, "<132>1 ") {
+		t.Errorf("got message %q, want priority 132", message)
+	}
+	for _, want := range []string{` c-1 cluster-agent - BackOff [event@53829 `, `namespace="default"`, `count="2"]`, "] Back-off restarting failed container"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("got message %q, want it to contain %q", message, want)
+		}
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		var messages []string
+		for {
+			// octet counting framing: <length> <message>
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil {
+				break
+			}
+			message := make([]byte, n)
+			if _, err := io.ReadFull(reader, message); err != nil {
+				break
+			}
+			messages = append(messages, string(message))
+		}
+		received <- messages
+	}()
+
+	facility := 1
+	sink, err := newSyslogSink("syslog", &SyslogConfig{Network: "tcp", Address: listener.Addr().String(), Facility: &facility})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Send(newRecord("first"))
+	sink.Send(newRecord(`second ] "quoted"`))
+	sink.Close()
+
+	messages := <-received
+	if len(messages) != 2 {
+		t.Fatalf("got messages %q, want 2", messages)
+	}
+	if !strings.HasPrefix(messages[0], "<12>1 ") || !strings.HasSuffix(messages[0], "] first") {
+		t.Errorf("got message %q", messages[0])
+	}
+	if !strings.HasSuffix(messages[1], `] second ] "quoted"`) {
+		t.Errorf("got message %q", messages[1])
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// newTestFileSink returns a file sink that isn't reading its queue, records are written directly
+func newTestFileSink(t *testing.T, config *FileConfig) *fileSink {
+	f := &fileSink{
+		queue:  newQueue("file"),
+		config: config,
+	}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+
+	f := newTestFileSink(t, &FileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 2})
+	// about 3MB of records, rotated twice
+	message := strings.Repeat("x", 1000)
+	for i := 0; i < 3000; i++ {
+		if err := f.write(newRecord(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.file.Close()
+
+	for _, name := range []string{path, backupPath(path, 1), backupPath(path, 2)} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024*1024 {
+			t.Errorf("[%s] is %d bytes, over the max size", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("got more backups than the max: %v", err)
+	}
+
+	record := &EventRecord{}
+	if err := json.Unmarshal([]byte(readLines(t, path)[0]), record); err != nil || record.Event.Message != message {
+		t.Errorf("got line %v, %v", record, err)
+	}
+}
+
+func TestFileSinkRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+
+	// the file can't be moved onto a directory
+	if err := os.MkdirAll(filepath.Join(backupPath(path, 1), "keep"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 1024*1024)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFileSink(t, &FileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 1})
+	for _, message := range []string{"first", "second"} {
+		if err := f.write(newRecord(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.file.Close()
+
+	lines := readLines(t, path)
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want the records appended to the file that couldn't be rotated", len(lines))
+	}
+	if !strings.Contains(lines[2], `"message":"second"`) {
+		t.Errorf("got last line %q", lines[2])
+	}
+}
+
+func TestSinksReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+	config := func(maxSizeMB int) string {
+		return fmt.Sprintf("sinks:\n- name: file\n  file:\n    path: %s\n    maxSizeMB: %d\n- name: other\n  file:\n    path: %s\n", path, maxSizeMB, filepath.Join(dir, "other.log"))
+	}
+
+	s := &Sinks{}
+	if err := s.load(config(10)); err != nil {
+		t.Fatal(err)
+	}
+	file, other := s.sinks["file"], s.sinks["other"]
+	s.send([]string{"file"}, newRecord("first"))
+
+	// unchanged config keeps the sinks
+	if err := s.load(config(10)); err != nil {
+		t.Fatal(err)
+	}
+	if s.sinks["file"] != file || s.sinks["other"] != other {
+		t.Error("unchanged sinks are replaced on reload")
+	}
+
+	// the changed sink is replaced once the old one is drained
+	if err := s.load(config(20)); err != nil {
+		t.Fatal(err)
+	}
+	if s.sinks["file"] == file || s.sinks["other"] != other {
+		t.Error("only the changed sink is expected to be replaced")
+	}
+	s.send([]string{"file"}, newRecord("second"))
+
+	// an invalid config keeps the last valid sinks
+	if err := s.load("sinks:\n- name: broken\n"); err == nil {
+		t.Error("no error for a sink without destination")
+	}
+	if len(s.sinks) != 2 {
+		t.Errorf("got %d sinks after an invalid config, want 2", len(s.sinks))
+	}
+
+	if err := s.load(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sinks) != 0 {
+		t.Errorf("got %d sinks for an empty config", len(s.sinks))
+	}
+
+	lines := readLines(t, path)
+	if len(lines) != 2 || !strings.Contains(lines[0], `"message":"first"`) || !strings.Contains(lines[1], `"message":"second"`) {
+		t.Errorf("got lines %q, want first and second in order", lines)
+	}
+}
+
+func TestSinksConfigValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{
+			name:  "sink without name",
+			value: "sinks:\n- file: {path: /tmp/events.log}\n",
+		},
+		{
+			name:  "sink without destination",
+			value: "sinks:\n- name: broken\n",
+		},
+		{
+			name:  "sink with two destinations",
+			value: "sinks:\n- name: both\n  file: {path: /tmp/events.log}\n  webhook: {url: 'http://127.0.0.1'}\n",
+		},
+		{
+			name:  "sinks with the same name",
+			value: "sinks:\n- name: dup\n  webhook: {url: 'http://127.0.0.1'}\n- name: dup\n  webhook: {url: 'http://127.0.0.2'}\n",
+		},
+	}
+
+	for _, test := range tests {
+		s := &Sinks{}
+		if err := s.load(test.value); err == nil {
+			t.Errorf("%s: got no error", test.name)
+		}
+		if len(s.sinks) != 0 {
+			t.Errorf("%s: got sinks %v for an invalid config", test.name, s.sinks)
+		}
+	}
+}
+
+// blockingSink blocks Close until it is released
+type blockingSink struct {
+	closing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSink) Send(record *EventRecord) {}
+
+func (b *blockingSink) Close() {
+	close(b.closing)
+	<-b.release
+}
+
+func TestSinksReloadDoesNotBlockSends(t *testing.T) {
+	sink := &blockingSink{closing: make(chan struct{}), release: make(chan struct{})}
+	s := &Sinks{
+		sinks:   map[string]Sink{"slow": sink},
+		configs: map[string]SinkConfig{"slow": {Name: "slow", Webhook: &WebhookConfig{URL: "http://127.0.0.1"}}},
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		loaded <- s.load("")
+	}()
+	<-sink.closing
+
+	sent := make(chan struct{})
+	go func() {
+		s.send([]string{"slow"}, newRecord("during close"))
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked while a replaced sink was closing")
+	}
+
+	close(sink.release)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sinks) != 0 {
+		t.Errorf("got %d sinks for an empty config", len(s.sinks))
+	}
+}
@@ -0,0 +1,149 @@
+package eventssyncer
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	spoolReplayInterval = 10 * time.Second
+)
+
+var (
+	// spoolRecords counts the ClusterEvents spooled, replayed, failed on replay and dropped on overflow
+	spoolRecords = expvar.NewMap("eventssyncer_spool")
+)
+
+// Spool holds the ClusterEvents that couldn't be created while management was unavailable, and
+// creates them in order once it is back. When full, the oldest ClusterEvent is dropped
+type Spool struct {
+	sync.Mutex
+	size    int
+	events  []*v3.ClusterEvent
+	dropped int
+	create  func(*v3.ClusterEvent) (*v3.ClusterEvent, error)
+}
+
+// NewSpool returns nil when size is 0, ClusterEvents are then created directly
+func NewSpool(size int, create func(*v3.ClusterEvent) (*v3.ClusterEvent, error)) *Spool {
+	if size <= 0 {
+		return nil
+	}
+	return &Spool{
+		size:   size,
+		create: create,
+	}
+}
+
+// Create creates the ClusterEvent, or spools it when management is unavailable. While
+// ClusterEvents are spooled, new ones are queued behind them to keep the order
+func (s *Spool) Create(clusterEvent *v3.ClusterEvent) error {
+	if s.len() == 0 {
+		_, err := s.create(clusterEvent)
+		if err == nil || !isUnavailable(err) {
+			return err
+		}
+		logrus.Warnf("Management is unavailable, spooling cluster events: %v", err)
+	}
+	s.push(clusterEvent)
+	return nil
+}
+
+func (s *Spool) len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.events)
+}
+
+// push queues the ClusterEvent, replacing the one already spooled under the same name
+func (s *Spool) push(clusterEvent *v3.ClusterEvent) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, spooled := range s.events {
+		if spooled.Namespace == clusterEvent.Namespace && spooled.Name != "" && spooled.Name == clusterEvent.Name {
+			s.events[i] = clusterEvent
+			return
+		}
+	}
+	if len(s.events) >= s.size {
+		s.events = s.events[1:]
+		s.dropped++
+		spoolRecords.Add("dropped", 1)
+	}
+	s.events = append(s.events, clusterEvent)
+	spoolRecords.Add("spooled", 1)
+}
+
+func (s *Spool) replay(ctx context.Context, interval time.Duration) {
+	for range utils.TickerContext(ctx, interval) {
+		s.replayAll()
+	}
+}
+
+// replayAll creates the spooled ClusterEvents in order, until management is unavailable again
+func (s *Spool) replayAll() {
+	replayed := 0
+	for {
+		s.Lock()
+		if len(s.events) == 0 {
+			s.Unlock()
+			break
+		}
+		clusterEvent := s.events[0]
+		s.Unlock()
+
+		_, err := s.create(clusterEvent)
+		if err != nil && isUnavailable(err) {
+			logrus.Debugf("Management is still unavailable, %d cluster events spooled: %v", s.len(), err)
+			break
+		}
+
+		s.Lock()
+		// the head may have been dropped by an overflow in the meantime
+		if len(s.events) > 0 && s.events[0] == clusterEvent {
+			s.events = s.events[1:]
+		}
+		s.Unlock()
+
+		switch {
+		case err == nil || apierrors.IsAlreadyExists(err):
+			replayed++
+			spoolRecords.Add("replayed", 1)
+		default:
+			spoolRecords.Add("failed", 1)
+			logrus.Warnf("Failed to replay cluster event [%s]: %v", clusterEvent.Message, err)
+		}
+	}
+
+	s.Lock()
+	dropped := s.dropped
+	if replayed > 0 {
+		s.dropped = 0
+	}
+	s.Unlock()
+	if replayed > 0 {
+		logrus.Infof("Replayed %d spooled cluster events, %d were dropped while management was unavailable", replayed, dropped)
+	}
+}
+
+// isUnavailable returns whether the error means management couldn't be reached, rather than
+// the ClusterEvent being rejected
+func isUnavailable(err error) bool {
+	if _, ok := errors.Cause(err).(apierrors.APIStatus); !ok {
+		return true
+	}
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err)
+}
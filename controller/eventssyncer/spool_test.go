@@ -0,0 +1,134 @@
+package eventssyncer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeManagement creates ClusterEvents while available, and fails as unreachable otherwise
+type fakeManagement struct {
+	available bool
+	rejected  map[string]error
+	created   []string
+}
+
+func (f *fakeManagement) create(clusterEvent *v3.ClusterEvent) (*v3.ClusterEvent, error) {
+	if !f.available {
+		return nil, errors.New("connection refused")
+	}
+	if err := f.rejected[clusterEvent.Name]; err != nil {
+		return nil, err
+	}
+	f.created = append(f.created, clusterEvent.Name)
+	return clusterEvent, nil
+}
+
+func newSpooledEvent(name string) *v3.ClusterEvent {
+	clusterEvent := &v3.ClusterEvent{}
+	clusterEvent.Namespace = "p-1"
+	clusterEvent.Name = name
+	return clusterEvent
+}
+
+func spooledNames(s *Spool) []string {
+	var names []string
+	for _, clusterEvent := range s.events {
+		names = append(names, clusterEvent.Name)
+	}
+	return names
+}
+
+func TestSpoolDrops(t *testing.T) {
+	management := &fakeManagement{}
+	s := NewSpool(3, management.create)
+
+	for i := 0; i < 5; i++ {
+		if err := s.Create(newSpooledEvent(fmt.Sprintf("event-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a repeating event replaces its spooled version, it isn't counted twice
+	if err := s.Create(newSpooledEvent("event-4")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"event-2", "event-3", "event-4"}; !reflect.DeepEqual(spooledNames(s), want) {
+		t.Errorf("got spooled %v, want %v", spooledNames(s), want)
+	}
+	if s.dropped != 2 {
+		t.Errorf("got %d dropped, want the 2 oldest", s.dropped)
+	}
+
+	// still unavailable, nothing replayed and the drops are kept for the next replay
+	s.replayAll()
+	if s.len() != 3 || s.dropped != 2 {
+		t.Errorf("got %d spooled and %d dropped while unavailable", s.len(), s.dropped)
+	}
+
+	management.available = true
+	s.replayAll()
+	if want := []string{"event-2", "event-3", "event-4"}; !reflect.DeepEqual(management.created, want) {
+		t.Errorf("got replayed %v, want %v in order", management.created, want)
+	}
+	if s.len() != 0 || s.dropped != 0 {
+		t.Errorf("got %d spooled and %d dropped after the replay", s.len(), s.dropped)
+	}
+
+	// new events go through right away once the spool is empty
+	if err := s.Create(newSpooledEvent("event-5")); err != nil || s.len() != 0 {
+		t.Errorf("got %v with %d spooled, want the event created", err, s.len())
+	}
+}
+
+func TestSpoolRejected(t *testing.T) {
+	invalid := apierrors.NewInvalid(schema.GroupKind{Kind: "ClusterEvent"}, "invalid", nil)
+	management := &fakeManagement{available: true, rejected: map[string]error{"invalid": invalid}}
+	s := NewSpool(3, management.create)
+
+	// rejected by management, retrying wouldn't help
+	if err := s.Create(newSpooledEvent("invalid")); err != invalid {
+		t.Errorf("got %v, want the error returned", err)
+	}
+	if s.len() != 0 {
+		t.Errorf("got %d spooled for a rejected event", s.len())
+	}
+
+	// rejected on replay, it is dropped and the replay goes on
+	management.available = false
+	for _, name := range []string{"event-1", "invalid", "event-2"} {
+		s.Create(newSpooledEvent(name))
+	}
+	management.available = true
+	s.replayAll()
+	if want := []string{"event-1", "event-2"}; !reflect.DeepEqual(management.created, want) {
+		t.Errorf("got replayed %v, want %v", management.created, want)
+	}
+	if s.len() != 0 {
+		t.Errorf("got %d spooled after the replay", s.len())
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{apierrors.NewServiceUnavailable("unavailable"), true},
+		{apierrors.NewTooManyRequests("slow down", 1), true},
+		{apierrors.NewInternalError(errors.New("etcd")), true},
+		{apierrors.NewAlreadyExists(schema.GroupResource{}, "event-1"), false},
+		{apierrors.NewForbidden(schema.GroupResource{}, "event-1", errors.New("denied")), false},
+	}
+
+	for _, test := range tests {
+		if got := isUnavailable(test.err); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package eventssyncer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// syslog facility local0
+	defaultSyslogFacility = 16
+	syslogSeverityWarning = 4
+	syslogSeverityInfo    = 6
+	syslogAppName         = "cluster-agent"
+	// syslogSDID is the structured data id of the event parameters
+	syslogSDID    = "event@53829"
+	syslogTimeout = 10 * time.Second
+)
+
+var syslogSDEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// SyslogConfig sends every record as an RFC5424 message. Over tcp, messages are framed
+// with octet counting as of RFC6587
+type SyslogConfig struct {
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Facility *int   `json:"facility,omitempty"`
+}
+
+type syslogSink struct {
+	*queue
+	config   *SyslogConfig
+	facility int
+	conn     net.Conn
+}
+
+func newSyslogSink(name string, config *SyslogConfig) (Sink, error) {
+	if config.Network != "tcp" && config.Network != "udp" {
+		return nil, errors.Errorf("syslog sink [%s] has invalid network [%s], expected tcp or udp", name, config.Network)
+	}
+	if config.Address == "" {
+		return nil, errors.Errorf("syslog sink [%s] has no address", name)
+	}
+	facility := defaultSyslogFacility
+	if config.Facility != nil {
+		facility = *config.Facility
+	}
+
+	s := &syslogSink{
+		queue:    newQueue(name),
+		config:   config,
+		facility: facility,
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *syslogSink) Close() {
+	s.close()
+}
+
+func (s *syslogSink) run() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for record := range s.records {
+		if err := s.write(formatSyslog(s.facility, record)); err != nil {
+			sinkRecords.Add(s.name+".failed", 1)
+			logrus.Warnf("Failed to send event to syslog sink [%s]: %v", s.name, err)
+			continue
+		}
+		sinkRecords.Add(s.name+".sent", 1)
+	}
+}
+
+// write sends the message, reconnecting once if the connection was lost
+func (s *syslogSink) write(message string) error {
+	if s.config.Network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout(s.config.Network, s.config.Address, syslogTimeout)
+			if err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = s.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func formatSyslog(facility int, record *EventRecord) string {
+	event := record.Event
+	severity := syslogSeverityInfo
+	if event.Type == corev1.EventTypeWarning {
+		severity = syslogSeverityWarning
+	}
+	timestamp := event.LastTimestamp.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	structuredData := fmt.Sprintf(`[%s cluster="%s" project="%s" namespace="%s" kind="%s" name="%s" count="%d"]`,
+		syslogSDID,
+		syslogSDEscaper.Replace(record.ClusterName),
+		syslogSDEscaper.Replace(record.ProjectID),
+		syslogSDEscaper.Replace(event.InvolvedObject.Namespace),
+		syslogSDEscaper.Replace(event.InvolvedObject.Kind),
+		syslogSDEscaper.Replace(event.InvolvedObject.Name),
+		event.Count)
+
+	return fmt.Sprintf("<%d>1 %s %s %s - %s %s %s",
+		facility*8+severity,
+		timestamp.UTC().Format(time.RFC3339),
+		syslogHeaderValue(record.ClusterName),
+		syslogAppName,
+		syslogHeaderValue(event.Reason),
+		structuredData,
+		event.Message)
+}
+
+// syslogHeaderValue returns the nil value for an empty header field, and strips spaces otherwise
+func syslogHeaderValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, " ", "_", -1)
+}
@@ -0,0 +1,123 @@
+package eventssyncer
+
+import (
+	"testing"
+
+	"github.com/rancher/types/apis/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type fakeNamespaces struct {
+	v1.NamespaceLister
+	namespaces map[string]*corev1.Namespace
+}
+
+func newFakeNamespaces(namespaces ...*corev1.Namespace) *fakeNamespaces {
+	f := &fakeNamespaces{namespaces: map[string]*corev1.Namespace{}}
+	for _, namespace := range namespaces {
+		f.namespaces[namespace.Name] = namespace
+	}
+	return f
+}
+
+func (f *fakeNamespaces) Get(namespace, name string) (*corev1.Namespace, error) {
+	if ns, ok := f.namespaces[name]; ok {
+		return ns, nil
+	}
+	return nil, notFound(name)
+}
+
+func (f *fakeNamespaces) List(namespace string, selector labels.Selector) ([]*corev1.Namespace, error) {
+	var result []*corev1.Namespace
+	for _, ns := range f.namespaces {
+		result = append(result, ns)
+	}
+	return result, nil
+}
+
+func newNamespace(name, projectID string) *corev1.Namespace {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	if projectID != "" {
+		namespace.Annotations = map[string]string{projectIDLabel: projectID}
+	}
+	return namespace
+}
+
+func TestGetEventNamespace(t *testing.T) {
+	clusterNamespaces := newFakeNamespaces(
+		newNamespace("in-project", "c-1:p-1"),
+		newNamespace("no-project", ""),
+		newNamespace("invalid-project", "p-1"),
+	)
+	managementNamespaces := newFakeNamespaces(
+		newNamespace("c-1", ""),
+		newNamespace("p-1", ""),
+	)
+
+	tests := []struct {
+		name       string
+		namespace  string
+		policy     string
+		want       string
+		wantReason string
+	}{
+		{name: "cluster scoped", policy: UnassignedPolicyDrop, want: "c-1"},
+		{name: "in a project", namespace: "in-project", policy: UnassignedPolicyDrop, want: "p-1"},
+		{name: "no project", namespace: "no-project", policy: UnassignedPolicyCluster, want: "c-1", wantReason: unassignedNoProject},
+		{name: "no project dropped", namespace: "no-project", policy: UnassignedPolicyDrop},
+		{name: "invalid project id", namespace: "invalid-project", policy: UnassignedPolicyCluster, want: "c-1", wantReason: unassignedNoProject},
+		{name: "deleted namespace", namespace: "deleted", policy: UnassignedPolicyCluster, want: "c-1", wantReason: unassignedNamespaceDeleted},
+		{name: "deleted namespace dropped", namespace: "deleted", policy: UnassignedPolicyDrop},
+	}
+
+	for _, test := range tests {
+		e := &EventsSyncer{
+			clusterName:          "c-1",
+			clusterNamespaces:    clusterNamespaces,
+			managementNamespaces: managementNamespaces,
+			unassignedPolicy:     test.policy,
+		}
+		event := &corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: test.namespace, Name: "pod-1"},
+		}
+
+		ns, reason, err := e.getEventNamespace(event)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := ""
+		if ns != nil {
+			got = ns.Name
+		}
+		if got != test.want || reason != test.wantReason {
+			t.Errorf("%s: got [%s] %q, want [%s] %q", test.name, got, reason, test.want, test.wantReason)
+		}
+	}
+}
+
+func TestConvertUnassignedEvent(t *testing.T) {
+	e := &EventsSyncer{clusterName: "c-1"}
+	event := newEvent("no-project", "pod-1.123", "uid-1")
+	event.Annotations = map[string]string{"team": "a"}
+
+	clusterEvent := e.convertEventToClusterEvent(event, newNamespace("c-1", ""), unassignedNoProject)
+	if clusterEvent.Annotations[unassignedReasonAnnotation] != unassignedNoProject || clusterEvent.Annotations["team"] != "a" {
+		t.Errorf("got annotations %v", clusterEvent.Annotations)
+	}
+	if _, ok := event.Annotations[unassignedReasonAnnotation]; ok {
+		t.Error("the annotations of the source event were modified")
+	}
+	if clusterEvent.Labels[sourceNamespaceLabel] != "no-project" || clusterEvent.Labels[clusterEventOwnerLabel] != "c-1" {
+		t.Errorf("got labels %v", clusterEvent.Labels)
+	}
+
+	assigned := e.convertEventToClusterEvent(newEvent("in-project", "pod-1.123", "uid-1"), newNamespace("p-1", ""), "")
+	if _, ok := assigned.Annotations[unassignedReasonAnnotation]; ok {
+		t.Error("an event of a project namespace is marked unassigned")
+	}
+}
@@ -0,0 +1,144 @@
+package eventssyncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebhookBatchSize     = 100
+	defaultWebhookFlushInterval = 5 * time.Second
+	defaultWebhookMaxRetries    = 3
+	webhookTimeout              = 10 * time.Second
+)
+
+// WebhookConfig posts batches of records as a json array
+type WebhookConfig struct {
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	BatchSize     int               `json:"batchSize,omitempty"`
+	FlushInterval string            `json:"flushInterval,omitempty"`
+	MaxRetries    int               `json:"maxRetries,omitempty"`
+}
+
+type webhookSink struct {
+	*queue
+	config        *WebhookConfig
+	flushInterval time.Duration
+	client        *http.Client
+}
+
+func newWebhookSink(name string, config *WebhookConfig) (Sink, error) {
+	if config.URL == "" {
+		return nil, errors.Errorf("webhook sink [%s] has no url", name)
+	}
+	// defaults are set on a copy, the config is compared on reload
+	copied := *config
+	config = &copied
+	flushInterval := defaultWebhookFlushInterval
+	if config.FlushInterval != "" {
+		interval, err := time.ParseDuration(config.FlushInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid flush interval of webhook sink [%s]", name)
+		}
+		flushInterval = interval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultWebhookBatchSize
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultWebhookMaxRetries
+	}
+
+	w := &webhookSink{
+		queue:         newQueue(name),
+		config:        config,
+		flushInterval: flushInterval,
+		client:        &http.Client{Timeout: webhookTimeout},
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *webhookSink) Close() {
+	w.close()
+}
+
+func (w *webhookSink) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	var batch []*EventRecord
+	for {
+		select {
+		case record, ok := <-w.records:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+func (w *webhookSink) flush(batch []*EventRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logrus.Errorf("Failed to encode events for webhook sink [%s]: %v", w.name, err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			sinkRecords.Add(w.name+".sent", int64(len(batch)))
+			return
+		}
+		if attempt >= w.config.MaxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	sinkRecords.Add(w.name+".failed", int64(len(batch)))
+	logrus.Warnf("Failed to post %d events to webhook sink [%s]: %v", len(batch), w.name, err)
+}
+
+func (w *webhookSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package nodesyncer
+
+import (
+	"expvar"
+	"reflect"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+var (
+	// machineWrites counts the Machine writes sent to the management API, and the updates deferred by the debounce
+	machineWrites = expvar.NewMap("nodesyncer_machine_writes")
+)
+
+// isUrgentUpdate is true for changes that have to reach management right away:
+// node conditions and taints
+func isUrgentUpdate(existing *v3.Machine, toUpdate *v3.Machine) bool {
+	existingToCompare, toUpdateToCompare := resetConditions(existing), resetConditions(toUpdate)
+	if !reflect.DeepEqual(existingToCompare.Status.NodeStatus.Conditions, toUpdateToCompare.Status.NodeStatus.Conditions) {
+		return true
+	}
+	if !reflect.DeepEqual(existing.Status.Conditions, toUpdate.Status.Conditions) {
+		return true
+	}
+	return !reflect.DeepEqual(existing.Spec.NodeSpec.Taints, toUpdate.Spec.NodeSpec.Taints)
+}
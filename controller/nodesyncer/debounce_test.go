@@ -0,0 +1,77 @@
+package nodesyncer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsUrgentUpdate(t *testing.T) {
+	ready := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}
+	heartbeat := ready
+	heartbeat.LastHeartbeatTime = metav1.NewTime(time.Now())
+	notReady := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionFalse}
+	taint := corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+
+	machine := func(conditions []corev1.NodeCondition, taints []corev1.Taint, labels map[string]string) *v3.Machine {
+		machine := &v3.Machine{}
+		machine.Status.NodeStatus.Conditions = conditions
+		machine.Spec.NodeSpec.Taints = taints
+		machine.Status.NodeLabels = labels
+		return machine
+	}
+
+	tests := []struct {
+		name     string
+		existing *v3.Machine
+		toUpdate *v3.Machine
+		want     bool
+	}{
+		{
+			name:     "no change",
+			existing: machine([]corev1.NodeCondition{ready}, nil, nil),
+			toUpdate: machine([]corev1.NodeCondition{ready}, nil, nil),
+		},
+		{
+			name:     "heartbeat only",
+			existing: machine([]corev1.NodeCondition{ready}, nil, nil),
+			toUpdate: machine([]corev1.NodeCondition{heartbeat}, nil, nil),
+		},
+		{
+			name:     "labels only",
+			existing: machine(nil, nil, nil),
+			toUpdate: machine(nil, nil, map[string]string{"zone": "a"}),
+		},
+		{
+			name:     "node condition status",
+			existing: machine([]corev1.NodeCondition{ready}, nil, nil),
+			toUpdate: machine([]corev1.NodeCondition{notReady}, nil, nil),
+			want:     true,
+		},
+		{
+			name:     "taint added",
+			existing: machine(nil, nil, nil),
+			toUpdate: machine(nil, []corev1.Taint{taint}, nil),
+			want:     true,
+		},
+		{
+			name:     "machine condition",
+			existing: machine(nil, nil, nil),
+			toUpdate: func() *v3.Machine {
+				toUpdate := machine(nil, nil, nil)
+				machineConditionNodeFound.False(toUpdate)
+				return toUpdate
+			}(),
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		if got := isUrgentUpdate(test.existing, test.toUpdate); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
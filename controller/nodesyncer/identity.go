@@ -0,0 +1,188 @@
+package nodesyncer
+
+import (
+	"strings"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// nodeIdentityAnnotation records the key a Machine was matched to its node with,
+	// in the form <kind>:<value>
+	nodeIdentityAnnotation = "machine.cluster.cattle.io/node-identity"
+
+	identityProviderID = "providerID"
+	identityMachineID  = "machineID"
+	identitySystemUUID = "systemUUID"
+	identityAddress    = "address"
+	identityHostname   = "hostname"
+)
+
+// identityKinds are ordered from the most to the least stable identity of a node
+var identityKinds = []string{
+	identityProviderID,
+	identityMachineID,
+	identitySystemUUID,
+	identityAddress,
+	identityHostname,
+}
+
+type machineMatch struct {
+	machine *v3.Machine
+	key     string
+}
+
+// matchMachinesToNodes pairs every node with at most one machine. Matching is done in passes, the
+// identity a machine was matched with before first, then one per identity kind, so a stable key like
+// providerID always wins over a hostname match that could be shared by a re-provisioned node
+func matchMachinesToNodes(machines []*v3.Machine, nodes []*corev1.Node) map[string]machineMatch {
+	passes := []func(*v3.Machine, *corev1.Node) string{recordedIdentity}
+	for _, kind := range identityKinds {
+		kind := kind
+		passes = append(passes, func(machine *v3.Machine, node *corev1.Node) string {
+			return matchIdentity(kind, machine, node)
+		})
+	}
+
+	matches := map[string]machineMatch{}
+	claimed := map[string]bool{}
+	for _, match := range passes {
+		for _, machine := range machines {
+			if claimed[machine.Name] {
+				continue
+			}
+			for _, node := range nodes {
+				if _, ok := matches[node.Name]; ok {
+					continue
+				}
+				if key := match(machine, node); key != "" {
+					matches[node.Name] = machineMatch{machine: machine, key: key}
+					claimed[machine.Name] = true
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// getMachineIdentityKey returns the key the machine matches the node with, or empty string
+func getMachineIdentityKey(machine *v3.Machine, node *corev1.Node) string {
+	if key := recordedIdentity(machine, node); key != "" {
+		return key
+	}
+	for _, kind := range identityKinds {
+		if key := matchIdentity(kind, machine, node); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// getNodeIdentityKey returns the most stable key of a node that has no machine yet
+func getNodeIdentityKey(node *corev1.Node) string {
+	for _, kind := range identityKinds {
+		if values := nodeIdentities(kind, node); len(values) > 0 {
+			return kind + ":" + values[0]
+		}
+	}
+	return ""
+}
+
+// recordedIdentity returns the identity recorded on the machine when it was last matched, if the node
+// still has it
+func recordedIdentity(machine *v3.Machine, node *corev1.Node) string {
+	key := machine.Annotations[nodeIdentityAnnotation]
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) != 2 || identitiesConflict(machine, node) {
+		return ""
+	}
+	for _, value := range nodeIdentities(parts[0], node) {
+		if value == parts[1] {
+			return key
+		}
+	}
+	return ""
+}
+
+func matchIdentity(kind string, machine *v3.Machine, node *corev1.Node) string {
+	if identitiesConflict(machine, node) {
+		return ""
+	}
+	machineValues := machineIdentities(kind, machine)
+	for _, value := range nodeIdentities(kind, node) {
+		for _, machineValue := range machineValues {
+			if value == machineValue {
+				return kind + ":" + value
+			}
+		}
+	}
+	return ""
+}
+
+// identitiesConflict is true when both the machine and the node carry a stable identity,
+// and they differ. The machine belonged to another node that used to have the same name or address
+func identitiesConflict(machine *v3.Machine, node *corev1.Node) bool {
+	for _, kind := range []string{identityProviderID, identityMachineID, identitySystemUUID} {
+		machineValues, nodeValues := machineIdentities(kind, machine), nodeIdentities(kind, node)
+		if len(machineValues) > 0 && len(nodeValues) > 0 && machineValues[0] != nodeValues[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeIdentities(kind string, node *corev1.Node) []string {
+	switch kind {
+	case identityProviderID:
+		return nonEmpty(node.Spec.ProviderID)
+	case identityMachineID:
+		return nonEmpty(node.Status.NodeInfo.MachineID)
+	case identitySystemUUID:
+		return nonEmpty(node.Status.NodeInfo.SystemUUID)
+	case identityAddress:
+		return getAddresses(node.Status.Addresses)
+	case identityHostname:
+		return nonEmpty(node.Name)
+	}
+	return nil
+}
+
+func machineIdentities(kind string, machine *v3.Machine) []string {
+	switch kind {
+	case identityProviderID:
+		return nonEmpty(machine.Spec.NodeSpec.ProviderID)
+	case identityMachineID:
+		return nonEmpty(machine.Status.NodeStatus.NodeInfo.MachineID)
+	case identitySystemUUID:
+		return nonEmpty(machine.Status.NodeStatus.NodeInfo.SystemUUID)
+	case identityAddress:
+		addresses := getAddresses(machine.Status.NodeStatus.Addresses)
+		if machine.Status.NodeConfig != nil {
+			addresses = append(addresses, nonEmpty(machine.Status.NodeConfig.Address)...)
+			addresses = append(addresses, nonEmpty(machine.Status.NodeConfig.InternalAddress)...)
+		}
+		return addresses
+	case identityHostname:
+		return nonEmpty(getNodeNameFromMachine(machine))
+	}
+	return nil
+}
+
+func getAddresses(addresses []corev1.NodeAddress) []string {
+	var result []string
+	for _, address := range addresses {
+		if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+			result = append(result, nonEmpty(address.Address)...)
+		}
+	}
+	return result
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
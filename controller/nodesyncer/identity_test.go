@@ -0,0 +1,211 @@
+package nodesyncer
+
+import (
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type identity struct {
+	providerID string
+	machineID  string
+	systemUUID string
+	address    string
+	hostname   string
+	// recorded is the identity recorded on the machine when it was last matched
+	recorded string
+}
+
+func (i identity) node() *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: i.hostname},
+	}
+	node.Spec.ProviderID = i.providerID
+	node.Status.NodeInfo.MachineID = i.machineID
+	node.Status.NodeInfo.SystemUUID = i.systemUUID
+	if i.address != "" {
+		node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: i.address}}
+	}
+	return node
+}
+
+func (i identity) machine(name string) *v3.Machine {
+	machine := &v3.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	machine.Spec.NodeSpec.ProviderID = i.providerID
+	machine.Status.NodeStatus.NodeInfo.MachineID = i.machineID
+	machine.Status.NodeStatus.NodeInfo.SystemUUID = i.systemUUID
+	if i.address != "" {
+		machine.Status.NodeStatus.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: i.address}}
+	}
+	machine.Status.NodeName = i.hostname
+	if i.recorded != "" {
+		machine.Annotations = map[string]string{nodeIdentityAnnotation: i.recorded}
+	}
+	return machine
+}
+
+func TestIdentitiesConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		machine identity
+		node    identity
+		want    bool
+	}{
+		{
+			name:    "same provider id",
+			machine: identity{providerID: "aws:///i-1", hostname: "node-1"},
+			node:    identity{providerID: "aws:///i-1", hostname: "node-1"},
+		},
+		{
+			name:    "different provider id",
+			machine: identity{providerID: "aws:///i-1", hostname: "node-1"},
+			node:    identity{providerID: "aws:///i-2", hostname: "node-1"},
+			want:    true,
+		},
+		{
+			name:    "different machine id",
+			machine: identity{machineID: "m-1", address: "10.0.0.1"},
+			node:    identity{machineID: "m-2", address: "10.0.0.1"},
+			want:    true,
+		},
+		{
+			name:    "different system uuid",
+			machine: identity{systemUUID: "u-1"},
+			node:    identity{systemUUID: "u-2"},
+			want:    true,
+		},
+		{
+			name:    "stable identity missing on the machine",
+			machine: identity{hostname: "node-1"},
+			node:    identity{providerID: "aws:///i-2", machineID: "m-2", hostname: "node-1"},
+		},
+		{
+			name:    "stable identity missing on the node",
+			machine: identity{providerID: "aws:///i-1", hostname: "node-1"},
+			node:    identity{hostname: "node-1"},
+		},
+		{
+			name:    "different address and hostname only",
+			machine: identity{address: "10.0.0.1", hostname: "node-1"},
+			node:    identity{address: "10.0.0.2", hostname: "node-2"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := identitiesConflict(test.machine.machine("machine-1"), test.node.node()); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchMachinesToNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		machines map[string]identity
+		nodes    []identity
+		// want maps node names to the name and key of the machine they are matched to
+		want map[string][2]string
+	}{
+		{
+			name:     "hostname",
+			machines: map[string]identity{"machine-1": {hostname: "node-1"}},
+			nodes:    []identity{{hostname: "node-1"}},
+			want:     map[string][2]string{"node-1": {"machine-1", "hostname:node-1"}},
+		},
+		{
+			name:     "renamed node keeps its machine by provider id",
+			machines: map[string]identity{"machine-1": {providerID: "aws:///i-1", hostname: "node-1"}},
+			nodes:    []identity{{providerID: "aws:///i-1", hostname: "node-1-renamed"}},
+			want:     map[string][2]string{"node-1-renamed": {"machine-1", "providerID:aws:///i-1"}},
+		},
+		{
+			name:     "re-provisioned node with the same name gets no machine",
+			machines: map[string]identity{"machine-1": {providerID: "aws:///i-1", hostname: "node-1"}},
+			nodes:    []identity{{providerID: "aws:///i-2", hostname: "node-1"}},
+			want:     map[string][2]string{},
+		},
+		{
+			name: "stable key wins over a shared address",
+			machines: map[string]identity{
+				"machine-1": {machineID: "m-1", address: "10.0.0.1"},
+				"machine-2": {machineID: "m-2", address: "10.0.0.1"},
+			},
+			nodes: []identity{{machineID: "m-2", address: "10.0.0.1", hostname: "node-2"}},
+			want:  map[string][2]string{"node-2": {"machine-2", "machineID:m-2"}},
+		},
+		{
+			name: "recorded identity wins over a shared address",
+			machines: map[string]identity{
+				"machine-1": {address: "10.0.0.1", hostname: "node-1"},
+				"machine-2": {address: "10.0.0.1", hostname: "node-2", recorded: "address:10.0.0.1"},
+			},
+			nodes: []identity{{address: "10.0.0.1", hostname: "node-3"}},
+			want:  map[string][2]string{"node-3": {"machine-2", "address:10.0.0.1"}},
+		},
+		{
+			name:     "recorded identity the node no longer has falls back to the other passes",
+			machines: map[string]identity{"machine-1": {address: "10.0.0.2", hostname: "node-1", recorded: "address:10.0.0.1"}},
+			nodes:    []identity{{address: "10.0.0.2", hostname: "node-1"}},
+			want:     map[string][2]string{"node-1": {"machine-1", "address:10.0.0.2"}},
+		},
+		{
+			name: "a machine is matched to one node only",
+			machines: map[string]identity{
+				"machine-1": {address: "10.0.0.1"},
+			},
+			nodes: []identity{
+				{address: "10.0.0.1", hostname: "node-1"},
+				{address: "10.0.0.1", hostname: "node-2"},
+			},
+			want: map[string][2]string{"node-1": {"machine-1", "address:10.0.0.1"}},
+		},
+	}
+
+	for _, test := range tests {
+		var machines []*v3.Machine
+		for name, identity := range test.machines {
+			machines = append(machines, identity.machine(name))
+		}
+		var nodes []*corev1.Node
+		for _, identity := range test.nodes {
+			nodes = append(nodes, identity.node())
+		}
+
+		matches := matchMachinesToNodes(machines, nodes)
+		if len(matches) != len(test.want) {
+			t.Errorf("%s: got %d matches, want %d", test.name, len(matches), len(test.want))
+		}
+		for node, want := range test.want {
+			match, ok := matches[node]
+			if !ok {
+				t.Errorf("%s: node [%s] not matched", test.name, node)
+				continue
+			}
+			if match.machine.Name != want[0] || match.key != want[1] {
+				t.Errorf("%s: node [%s] got [%s] by [%s], want [%s] by [%s]", test.name, node, match.machine.Name, match.key, want[0], want[1])
+			}
+		}
+	}
+}
+
+func TestGetNodeIdentityKey(t *testing.T) {
+	tests := []struct {
+		node identity
+		want string
+	}{
+		{identity{providerID: "aws:///i-1", machineID: "m-1", hostname: "node-1"}, "providerID:aws:///i-1"},
+		{identity{systemUUID: "u-1", address: "10.0.0.1", hostname: "node-1"}, "systemUUID:u-1"},
+		{identity{address: "10.0.0.1", hostname: "node-1"}, "address:10.0.0.1"},
+		{identity{hostname: "node-1"}, "hostname:node-1"},
+	}
+
+	for _, test := range tests {
+		if got := getNodeIdentityKey(test.node.node()); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
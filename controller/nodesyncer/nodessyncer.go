@@ -1,10 +1,15 @@
 package nodesyncer
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/norman/condition"
 	"github.com/rancher/types/apis/core/v1"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/rancher/types/config"
@@ -13,12 +18,33 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	allMachineKey = "_machine_all_"
+	// nodeNotFoundSinceAnnotation is set on a Machine when its node disappears from the cluster,
+	// and holds the time the node was first found missing
+	nodeNotFoundSinceAnnotation = "machine.cluster.cattle.io/node-not-found-since"
 )
 
+var (
+	// machineConditionNodeFound is false while the Machine's node is missing from the cluster
+	machineConditionNodeFound condition.Cond = "NodeFound"
+)
+
+type Options struct {
+	// MachineGracePeriod is how long a Machine is kept after its node is gone,
+	// before it gets deleted. Zero deletes the Machine right away
+	MachineGracePeriod time.Duration
+	// UsageRefreshInterval is how often node usage is read from the metrics API.
+	// Zero disables reporting usage on Machines
+	UsageRefreshInterval time.Duration
+	// MachineUpdateInterval is the minimum time between two updates of a Machine,
+	// except for node condition and taint changes
+	MachineUpdateInterval time.Duration
+}
+
 type NodeSyncer struct {
 	machines         v3.MachineInterface
 	clusterNamespace string
@@ -35,10 +61,20 @@ type MachinesSyncer struct {
 	machineLister    v3.MachineLister
 	nodeLister       v1.NodeLister
 	podLister        v1.PodLister
+	clusterLister    v3.ClusterLister
+	clusters         v3.ClusterInterface
 	clusterNamespace string
+	gracePeriod      time.Duration
+	usage            *UsageSyncer
+	debouncer        *utils.Debouncer
+	events           record.EventRecorder
+
+	// timers holds the pending delayed reconcile of each machine, there is at most one per machine
+	timersLock sync.Mutex
+	timers     map[string]*time.Timer
 }
 
-func Register(cluster *config.ClusterContext) {
+func Register(ctx context.Context, cluster *config.ClusterContext, opts Options, events *utils.Recorders) {
 	n := &NodeSyncer{
 		clusterNamespace: cluster.ClusterName,
 		machines:         cluster.Management.Management.Machines(cluster.ClusterName),
@@ -50,6 +86,21 @@ func Register(cluster *config.ClusterContext) {
 		machineLister:    cluster.Management.Management.Machines(cluster.ClusterName).Controller().Lister(),
 		nodeLister:       cluster.Core.Nodes("").Controller().Lister(),
 		podLister:        cluster.Core.Pods("").Controller().Lister(),
+		clusterLister:    cluster.Management.Management.Clusters("").Controller().Lister(),
+		clusters:         cluster.Management.Management.Clusters(""),
+		gracePeriod:      opts.MachineGracePeriod,
+		debouncer:        utils.NewDebouncer(opts.MachineUpdateInterval),
+		events:           events.Management,
+	}
+
+	if opts.UsageRefreshInterval > 0 {
+		m.usage = &UsageSyncer{
+			clusterNamespace: cluster.ClusterName,
+			machines:         cluster.Management.Management.Machines(cluster.ClusterName),
+			podLister:        cluster.Core.Pods("").Controller().Lister(),
+			restClient:       cluster.K8sClient.Discovery().RESTClient(),
+		}
+		go m.usage.syncUsage(ctx, opts.UsageRefreshInterval)
 	}
 
 	p := &PodsStatsSyncer{
@@ -85,51 +136,55 @@ func (m *MachinesSyncer) reconcileAll() error {
 		return err
 	}
 
-	nodeMap := make(map[string]*corev1.Node)
-	for _, node := range nodes {
-		nodeMap[node.Name] = node
-	}
-
 	machines, err := m.machineLister.List(m.clusterNamespace, labels.NewSelector())
-	machineMap := make(map[string]*v3.Machine)
-	for _, machine := range machines {
-		nodeName := getNodeNameFromMachine(machine)
-		if nodeName == "" {
-			logrus.Warnf("Failed to get nodeName from machine [%s]", machine.Name)
-			continue
-		}
-		machineMap[nodeName] = machine
+	if err != nil {
+		return err
 	}
+	matches := matchMachinesToNodes(machines, nodes)
+
 	nodeToPodMap, err := m.getNonTerminatedPods()
 	if err != nil {
 		return err
 	}
 
 	// reconcile machines for existing nodes
-	for name, node := range nodeMap {
-		machine, _ := machineMap[name]
-		err = m.reconcileMachineForNode(machine, node, nodeToPodMap)
+	matched := map[string]bool{}
+	for _, node := range nodes {
+		match := matches[node.Name]
+		if match.machine != nil {
+			matched[match.machine.Name] = true
+			if _, ok := match.machine.Annotations[nodeNotFoundSinceAnnotation]; ok {
+				// the node is back, the machine is no longer waiting for the grace period to end
+				m.cancelEnqueue(match.machine.Name)
+			}
+		}
+		err = m.reconcileMachineForNode(match.machine, match.key, node, nodeToPodMap)
 		if err != nil {
 			return err
 		}
 	}
 	// run the logic for machine to remove
-	for name, machine := range machineMap {
-		if _, ok := nodeMap[name]; !ok {
-			if err := m.removeMachine(machine); err != nil {
-				return err
-			}
+	for _, machine := range machines {
+		if matched[machine.Name] {
+			continue
+		}
+		if getNodeNameFromMachine(machine) == "" {
+			logrus.Warnf("Failed to get nodeName from machine [%s]", machine.Name)
+			continue
+		}
+		if err := m.removeMachine(machine); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	return m.syncInventory(nodes)
 }
 
-func (m *MachinesSyncer) reconcileMachineForNode(machine *v3.Machine, node *corev1.Node, pods map[string][]*corev1.Pod) error {
+func (m *MachinesSyncer) reconcileMachineForNode(machine *v3.Machine, key string, node *corev1.Node, pods map[string][]*corev1.Pod) error {
 	if machine == nil {
 		return m.createMachine(node, pods)
 	}
-	return m.updateMachine(machine, node, pods)
+	return m.updateMachine(machine, key, node, pods)
 }
 
 func (m *MachinesSyncer) removeMachine(machine *v3.Machine) error {
@@ -137,16 +192,101 @@ func (m *MachinesSyncer) removeMachine(machine *v3.Machine) error {
 	if machine.Spec.MachineTemplateName != "" {
 		return nil
 	}
+	if m.gracePeriod > 0 {
+		since, ok := nodeNotFoundSince(machine)
+		if !ok {
+			return m.markMachineNotFound(machine)
+		}
+		if remaining := m.gracePeriod - time.Since(since); remaining > 0 {
+			m.enqueueAfter(machine.Name, remaining)
+			return nil
+		}
+	}
 	err := m.machines.Delete(machine.ObjectMeta.Name, nil)
 	if err != nil {
+		m.events.Eventf(machine, corev1.EventTypeWarning, "DeleteFailed", "Failed to delete machine: %v", err)
 		return errors.Wrapf(err, "Failed to delete machine [%s]", machine.Name)
 	}
+	m.events.Eventf(machine, corev1.EventTypeNormal, "Deleted", "Deleted machine of removed node [%s]", getNodeNameFromMachine(machine))
+	machineWrites.Add("delete", 1)
+	m.debouncer.Forget(machine.Name)
+	m.cancelEnqueue(machine.Name)
 	logrus.Infof("Deleted cluster node [%s]", machine.Name)
 	return nil
 }
 
-func (m *MachinesSyncer) updateMachine(existing *v3.Machine, node *corev1.Node, pods map[string][]*corev1.Pod) error {
-	toUpdate, err := m.convertNodeToMachine(node, existing, pods)
+// markMachineNotFound keeps the Machine of a missing node around for the grace period,
+// so the same Machine is picked up again if the node comes back
+func (m *MachinesSyncer) markMachineNotFound(machine *v3.Machine) error {
+	toUpdate := machine.DeepCopy()
+	if toUpdate.Annotations == nil {
+		toUpdate.Annotations = map[string]string{}
+	}
+	toUpdate.Annotations[nodeNotFoundSinceAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	// the status of a condition isn't set when the condition is created by the same call
+	machineConditionNodeFound.CreateUnknownIfNotExists(toUpdate)
+	machineConditionNodeFound.False(toUpdate)
+	machineConditionNodeFound.Reason(toUpdate, "NotFound")
+	machineConditionNodeFound.Message(toUpdate, fmt.Sprintf("node [%s] not found in cluster", getNodeNameFromMachine(machine)))
+	if _, err := m.machines.Update(toUpdate); err != nil {
+		return errors.Wrapf(err, "Failed to mark machine [%s] as not found", machine.Name)
+	}
+	m.events.Eventf(machine, corev1.EventTypeWarning, "NodeNotFound", "Node [%s] not found in cluster, machine will be deleted in %v", getNodeNameFromMachine(machine), m.gracePeriod)
+	machineWrites.Add("update", 1)
+	m.debouncer.Written(machine.Name)
+	logrus.Infof("Node for machine [%s] not found, machine will be deleted in %v", machine.Name, m.gracePeriod)
+	m.enqueueAfter(machine.Name, m.gracePeriod)
+	return nil
+}
+
+// enqueueAfter reconciles the machines once the delay is over. A pending reconcile of the machine is
+// replaced, so rescheduling a machine doesn't pile up timers
+func (m *MachinesSyncer) enqueueAfter(machineName string, after time.Duration) {
+	m.timersLock.Lock()
+	defer m.timersLock.Unlock()
+	if m.timers == nil {
+		m.timers = map[string]*time.Timer{}
+	}
+	if timer, ok := m.timers[machineName]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(after, func() {
+		m.timersLock.Lock()
+		if m.timers[machineName] == timer {
+			delete(m.timers, machineName)
+		}
+		m.timersLock.Unlock()
+		m.machines.Controller().Enqueue(m.clusterNamespace, allMachineKey)
+	})
+	m.timers[machineName] = timer
+}
+
+// cancelEnqueue drops the pending reconcile of the machine
+func (m *MachinesSyncer) cancelEnqueue(machineName string) {
+	m.timersLock.Lock()
+	defer m.timersLock.Unlock()
+	if timer, ok := m.timers[machineName]; ok {
+		timer.Stop()
+		delete(m.timers, machineName)
+	}
+}
+
+func nodeNotFoundSince(machine *v3.Machine) (time.Time, bool) {
+	value, ok := machine.Annotations[nodeNotFoundSinceAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		logrus.Warnf("Invalid [%s] annotation on machine [%s]: %v", nodeNotFoundSinceAnnotation, machine.Name, err)
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+func (m *MachinesSyncer) updateMachine(existing *v3.Machine, key string, node *corev1.Node, pods map[string][]*corev1.Pod) error {
+	toUpdate, err := m.convertNodeToMachine(node, key, existing, pods)
 	if err != nil {
 		return err
 	}
@@ -154,76 +294,69 @@ func (m *MachinesSyncer) updateMachine(existing *v3.Machine, node *corev1.Node,
 	if objectsAreEqual(existing, toUpdate) {
 		return nil
 	}
+	if !isUrgentUpdate(existing, toUpdate) {
+		if after, scheduled := m.debouncer.Wait(existing.Name); after > 0 {
+			// the latest state gets written when the machine is reconciled after the interval
+			machineWrites.Add("deferred", 1)
+			if scheduled {
+				m.enqueueAfter(existing.Name, after)
+			}
+			return nil
+		}
+	}
+	if existing.Annotations[nodeIdentityAnnotation] == "" {
+		logrus.Infof("Migrating machine [%s] to node identity [%s]", existing.Name, key)
+		m.events.Eventf(existing, corev1.EventTypeNormal, "IdentityMigrated", "Matched to node [%s] by %s", node.Name, key)
+	}
 	logrus.Debugf("Updating machine for node [%s]", node.Name)
 	_, err = m.machines.Update(toUpdate)
 	if err != nil {
+		m.events.Eventf(existing, corev1.EventTypeWarning, "UpdateFailed", "Failed to update machine from node [%s]: %v", node.Name, err)
 		return errors.Wrapf(err, "Failed to update machine for node [%s]", node.Name)
 	}
+	machineWrites.Add("update", 1)
+	m.debouncer.Written(existing.Name)
 	logrus.Debugf("Updated machine for node [%s]", node.Name)
 	return nil
 }
 
 func (m *MachinesSyncer) createMachine(node *corev1.Node, pods map[string][]*corev1.Pod) error {
-	// try to get machine from api, in case cache didn't get the update
-	existing, err := m.getMachineForNode(node.Name, false)
+	existing, key, err := m.getMachineForNode(node)
 	if err != nil {
 		return err
 	}
 	if existing != nil {
 		return nil
 	}
-	machine, err := m.convertNodeToMachine(node, existing, pods)
+	machine, err := m.convertNodeToMachine(node, key, existing, pods)
 	if err != nil {
 		return err
 	}
 
-	_, err = m.machines.Create(machine)
+	created, err := m.machines.Create(machine)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to create machine for node [%s]", node.Name)
 	}
+	m.events.Eventf(created, corev1.EventTypeNormal, "Created", "Created machine for node [%s]", node.Name)
+	machineWrites.Add("create", 1)
 	logrus.Infof("Created machine for node [%s]", node.Name)
 	return nil
 }
 
-func (m *MachinesSyncer) getMachineForNode(nodeName string, cache bool) (*v3.Machine, error) {
-	if cache {
-		machines, err := m.machineLister.List(m.clusterNamespace, labels.NewSelector())
-		if err != nil {
-			return nil, err
-		}
-		for _, machine := range machines {
-			if isMachineForNode(nodeName, machine) {
-				return machine, nil
-			}
-		}
-	} else {
-		machines, err := m.machines.List(metav1.ListOptions{})
-		if err != nil {
-			return nil, err
-		}
-		for _, machine := range machines.Items {
-			if machine.Namespace == m.clusterNamespace {
-				if isMachineForNode(nodeName, &machine) {
-					return &machine, nil
-				}
-			}
-		}
-	}
-
-	return nil, nil
-}
-
-func isMachineForNode(nodeName string, machine *v3.Machine) bool {
-	if machine.Status.NodeName == nodeName {
-		return true
+// getMachineForNode reads the machine of the node from the API, in case the cache didn't get it yet
+func (m *MachinesSyncer) getMachineForNode(node *corev1.Node) (*v3.Machine, string, error) {
+	machines, err := m.machines.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, "", err
 	}
-	// to handle the case when machine was provisioned first
-	if machine.Status.NodeConfig != nil {
-		if machine.Status.NodeConfig.HostnameOverride == nodeName {
-			return true
+	for _, machine := range machines.Items {
+		if machine.Namespace == m.clusterNamespace {
+			if key := getMachineIdentityKey(&machine, node); key != "" {
+				return &machine, key, nil
+			}
 		}
 	}
-	return false
+	return nil, getNodeIdentityKey(node), nil
 }
 
 func getNodeNameFromMachine(machine *v3.Machine) string {
@@ -263,13 +396,17 @@ func objectsAreEqual(existing *v3.Machine, toUpdate *v3.Machine) bool {
 	labelsEqual := reflect.DeepEqual(toUpdateToCompare.Status.NodeLabels, existing.Status.NodeLabels)
 	annotationsEqual := reflect.DeepEqual(toUpdateToCompare.Status.NodeAnnotations, existing.Status.NodeAnnotations)
 	specEqual := reflect.DeepEqual(toUpdateToCompare.Spec.NodeSpec, existingToCompare.Spec.NodeSpec)
+	rolesEqual := reflect.DeepEqual(toUpdateToCompare.Spec.Role, existingToCompare.Spec.Role)
 	nodeNameEqual := toUpdateToCompare.Status.NodeName == existingToCompare.Status.NodeName
+	identityEqual := toUpdateToCompare.Annotations[nodeIdentityAnnotation] == existingToCompare.Annotations[nodeIdentityAnnotation]
+	usageEqual := toUpdateToCompare.Annotations[usageAnnotation] == existingToCompare.Annotations[usageAnnotation]
 	requestsEqual := isEqual(toUpdateToCompare.Status.Requested, existingToCompare.Status.Requested)
 	limitsEqual := isEqual(toUpdateToCompare.Status.Limits, existingToCompare.Status.Limits)
-	return statusEqual && specEqual && nodeNameEqual && labelsEqual && annotationsEqual && requestsEqual && limitsEqual
+	notFoundEqual := toUpdateToCompare.Annotations[nodeNotFoundSinceAnnotation] == existingToCompare.Annotations[nodeNotFoundSinceAnnotation]
+	return statusEqual && specEqual && rolesEqual && nodeNameEqual && labelsEqual && annotationsEqual && requestsEqual && limitsEqual && notFoundEqual && identityEqual && usageEqual
 }
 
-func (m *MachinesSyncer) convertNodeToMachine(node *corev1.Node, existing *v3.Machine, pods map[string][]*corev1.Pod) (*v3.Machine, error) {
+func (m *MachinesSyncer) convertNodeToMachine(node *corev1.Node, key string, existing *v3.Machine, pods map[string][]*corev1.Pod) (*v3.Machine, error) {
 	var machine *v3.Machine
 	if existing == nil {
 		machine = &v3.Machine{
@@ -287,6 +424,13 @@ func (m *MachinesSyncer) convertNodeToMachine(node *corev1.Node, existing *v3.Ma
 		machine = existing.DeepCopy()
 		machine.Spec.NodeSpec = *node.Spec.DeepCopy()
 		machine.Status.NodeStatus = *node.Status.DeepCopy()
+		// node is back within the grace period
+		if _, ok := machine.Annotations[nodeNotFoundSinceAnnotation]; ok {
+			delete(machine.Annotations, nodeNotFoundSinceAnnotation)
+			machineConditionNodeFound.True(machine)
+			machineConditionNodeFound.Reason(machine, "")
+			machineConditionNodeFound.Message(machine, "")
+		}
 	}
 
 	requests, limits := aggregateRequestAndLimitsForNode(pods[node.Name])
@@ -307,6 +451,16 @@ func (m *MachinesSyncer) convertNodeToMachine(node *corev1.Node, existing *v3.Ma
 	machine.Status.NodeAnnotations = node.Annotations
 	machine.Status.NodeLabels = node.Labels
 	machine.Status.NodeName = node.Name
+	if key != "" {
+		if machine.Annotations == nil {
+			machine.Annotations = map[string]string{}
+		}
+		machine.Annotations[nodeIdentityAnnotation] = key
+	}
+	setUsageAnnotation(machine, m.usage.getUsage(node))
+	if isImportedMachine(machine) {
+		machine.Spec.Role = getNodeRoles(node)
+	}
 	machine.APIVersion = "management.cattle.io/v3"
 	machine.Kind = "Machine"
 	return machine, nil
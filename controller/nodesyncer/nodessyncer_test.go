@@ -0,0 +1,219 @@
+package nodesyncer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeMachines struct {
+	v3.MachineInterface
+	updated    []*v3.Machine
+	deleted    []string
+	deleteErr  error
+	controller *fakeMachineController
+}
+
+func (f *fakeMachines) Update(machine *v3.Machine) (*v3.Machine, error) {
+	f.updated = append(f.updated, machine)
+	return machine, nil
+}
+
+func (f *fakeMachines) Delete(name string, options *metav1.DeleteOptions) error {
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+func newMachine(notFoundSince string) *v3.Machine {
+	machine := &v3.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "machine-1",
+			Namespace: "c-1",
+		},
+	}
+	machine.Status.NodeName = "node-1"
+	if notFoundSince != "" {
+		machine.Annotations = map[string]string{nodeNotFoundSinceAnnotation: notFoundSince}
+	}
+	return machine
+}
+
+func TestRemoveMachine(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name        string
+		machine     *v3.Machine
+		gracePeriod time.Duration
+		deleteErr   error
+		wantErr     bool
+		wantMarked  bool
+		wantDeleted bool
+	}{
+		{
+			name:        "no grace period deletes right away",
+			machine:     newMachine(""),
+			wantDeleted: true,
+		},
+		{
+			name:        "first time missing is marked",
+			machine:     newMachine(""),
+			gracePeriod: time.Hour,
+			wantMarked:  true,
+		},
+		{
+			name:        "within the grace period is kept",
+			machine:     newMachine(now.Add(-30 * time.Minute).Format(time.RFC3339)),
+			gracePeriod: time.Hour,
+		},
+		{
+			name:        "after the grace period is deleted",
+			machine:     newMachine(now.Add(-2 * time.Hour).Format(time.RFC3339)),
+			gracePeriod: time.Hour,
+			wantDeleted: true,
+		},
+		{
+			name:        "invalid annotation is marked again",
+			machine:     newMachine("yesterday"),
+			gracePeriod: time.Hour,
+			wantMarked:  true,
+		},
+		{
+			name: "rke machine is never deleted",
+			machine: func() *v3.Machine {
+				machine := newMachine("")
+				machine.Spec.MachineTemplateName = "template-1"
+				return machine
+			}(),
+		},
+		{
+			name:      "delete failure is returned",
+			machine:   newMachine(""),
+			deleteErr: errors.New("unavailable"),
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		machines := &fakeMachines{deleteErr: test.deleteErr}
+		m := &MachinesSyncer{
+			machines:         machines,
+			clusterNamespace: "c-1",
+			gracePeriod:      test.gracePeriod,
+			events:           record.NewFakeRecorder(10),
+		}
+
+		err := m.removeMachine(test.machine)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		if got := len(machines.deleted) == 1; got != test.wantDeleted {
+			t.Errorf("%s: got deleted %v, want %v", test.name, got, test.wantDeleted)
+		}
+		if got := len(machines.updated) == 1; got != test.wantMarked {
+			t.Errorf("%s: got marked %v, want %v", test.name, got, test.wantMarked)
+			continue
+		}
+		if test.wantMarked {
+			marked := machines.updated[0]
+			if _, ok := nodeNotFoundSince(marked); !ok {
+				t.Errorf("%s: marked machine has no valid [%s] annotation", test.name, nodeNotFoundSinceAnnotation)
+			}
+			if !machineConditionNodeFound.IsFalse(marked) {
+				t.Errorf("%s: marked machine condition [%s] is not false", test.name, machineConditionNodeFound)
+			}
+		}
+	}
+}
+
+func TestEnqueueAfterKeepsOneTimerPerMachine(t *testing.T) {
+	enqueued := make(chan struct{}, 1)
+	machines := &fakeMachines{controller: &fakeMachineController{notify: enqueued}}
+	m := &MachinesSyncer{
+		machines:         machines,
+		clusterNamespace: "c-1",
+		gracePeriod:      time.Hour,
+		events:           record.NewFakeRecorder(10),
+	}
+	machine := newMachine(time.Now().UTC().Add(-30 * time.Minute).Format(time.RFC3339))
+
+	// every reconcile of the missing node reschedules the same machine
+	for i := 0; i < 3; i++ {
+		if err := m.removeMachine(machine); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.timers) != 1 {
+		t.Errorf("got %d pending reconciles, want 1", len(m.timers))
+	}
+
+	m.cancelEnqueue(machine.Name)
+	if len(m.timers) != 0 {
+		t.Errorf("got %d pending reconciles once cancelled, want none", len(m.timers))
+	}
+
+	m.enqueueAfter(machine.Name, time.Millisecond)
+	select {
+	case <-enqueued:
+	case <-time.After(time.Second):
+		t.Fatal("machines not reconciled after the delay")
+	}
+	m.timersLock.Lock()
+	pending := len(m.timers)
+	m.timersLock.Unlock()
+	if pending != 0 {
+		t.Errorf("got %d pending reconciles after the delay, want none", pending)
+	}
+}
+
+func TestConvertNodeToMachineClearsNotFound(t *testing.T) {
+	existing := newMachine(time.Now().UTC().Format(time.RFC3339))
+	machineConditionNodeFound.CreateUnknownIfNotExists(existing)
+	machineConditionNodeFound.False(existing)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+	}
+
+	m := &MachinesSyncer{clusterNamespace: "c-1"}
+	machine, err := m.convertNodeToMachine(node, "hostname:node-1", existing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := machine.Annotations[nodeNotFoundSinceAnnotation]; ok {
+		t.Errorf("[%s] annotation is kept once the node is back", nodeNotFoundSinceAnnotation)
+	}
+	if !machineConditionNodeFound.IsTrue(machine) {
+		t.Errorf("condition [%s] is not true once the node is back", machineConditionNodeFound)
+	}
+	if _, ok := existing.Annotations[nodeNotFoundSinceAnnotation]; !ok {
+		t.Error("the cached machine was modified")
+	}
+}
+
+func (f *fakeMachines) Controller() v3.MachineController {
+	if f.controller == nil {
+		f.controller = &fakeMachineController{}
+	}
+	return f.controller
+}
+
+type fakeMachineController struct {
+	v3.MachineController
+	enqueued int
+	// notify, if set, is sent to on every enqueue
+	notify chan struct{}
+}
+
+func (f *fakeMachineController) Enqueue(namespace, name string) {
+	f.enqueued++
+	if f.notify != nil {
+		f.notify <- struct{}{}
+	}
+}
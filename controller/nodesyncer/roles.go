@@ -0,0 +1,126 @@
+package nodesyncer
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	roleEtcd         = "etcd"
+	roleControlPlane = "controlplane"
+	roleWorker       = "worker"
+
+	// inventoryAnnotation holds the json encoded nodeInventory of the cluster
+	inventoryAnnotation = "cluster.cattle.io/node-inventory"
+)
+
+// roleLabels are the well known node labels set by rke, kubeadm and kops
+var roleLabels = map[string]string{
+	"node-role.kubernetes.io/etcd":         roleEtcd,
+	"node-role.kubernetes.io/controlplane": roleControlPlane,
+	"node-role.kubernetes.io/master":       roleControlPlane,
+	"node-role.kubernetes.io/worker":       roleWorker,
+	"node-role.kubernetes.io/node":         roleWorker,
+}
+
+// roleTaints are the well known taints keeping workloads off etcd and control plane nodes
+var roleTaints = map[string]string{
+	"node-role.kubernetes.io/etcd":         roleEtcd,
+	"node-role.kubernetes.io/controlplane": roleControlPlane,
+	"node-role.kubernetes.io/master":       roleControlPlane,
+}
+
+// nodeInventory counts the nodes of the cluster per OS image, kernel, container runtime and kubelet version
+type nodeInventory struct {
+	OSImage                 map[string]int `json:"osImage"`
+	KernelVersion           map[string]int `json:"kernelVersion"`
+	ContainerRuntimeVersion map[string]int `json:"containerRuntimeVersion"`
+	KubeletVersion          map[string]int `json:"kubeletVersion"`
+}
+
+// getNodeRoles infers the roles of a node that wasn't provisioned by rancher
+func getNodeRoles(node *corev1.Node) []string {
+	found := map[string]bool{}
+	for label, role := range roleLabels {
+		if _, ok := node.Labels[label]; ok {
+			found[role] = true
+		}
+	}
+	// kops
+	switch node.Labels["kubernetes.io/role"] {
+	case "master":
+		found[roleControlPlane] = true
+	case "node":
+		found[roleWorker] = true
+	}
+	for _, taint := range node.Spec.Taints {
+		if role, ok := roleTaints[taint.Key]; ok {
+			found[role] = true
+		}
+	}
+	// a node nothing is known about runs workloads
+	if len(found) == 0 {
+		found[roleWorker] = true
+	}
+
+	var roles []string
+	for _, role := range []string{roleEtcd, roleControlPlane, roleWorker} {
+		if found[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func isImportedMachine(machine *v3.Machine) bool {
+	return machine.Spec.MachineTemplateName == "" && machine.Status.NodeConfig == nil
+}
+
+func getNodeInventory(nodes []*corev1.Node) *nodeInventory {
+	inventory := &nodeInventory{
+		OSImage:                 map[string]int{},
+		KernelVersion:           map[string]int{},
+		ContainerRuntimeVersion: map[string]int{},
+		KubeletVersion:          map[string]int{},
+	}
+	for _, node := range nodes {
+		info := node.Status.NodeInfo
+		inventory.OSImage[info.OSImage]++
+		inventory.KernelVersion[info.KernelVersion]++
+		inventory.ContainerRuntimeVersion[info.ContainerRuntimeVersion]++
+		inventory.KubeletVersion[info.KubeletVersion]++
+	}
+	return inventory
+}
+
+func (m *MachinesSyncer) syncInventory(nodes []*corev1.Node) error {
+	data, err := json.Marshal(getNodeInventory(nodes))
+	if err != nil {
+		return err
+	}
+
+	cluster, err := m.clusterLister.Get("", m.clusterNamespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if cluster.DeletionTimestamp != nil || cluster.Annotations[inventoryAnnotation] == string(data) {
+		return nil
+	}
+
+	cluster = cluster.DeepCopy()
+	if cluster.Annotations == nil {
+		cluster.Annotations = map[string]string{}
+	}
+	cluster.Annotations[inventoryAnnotation] = string(data)
+	if _, err := m.clusters.Update(cluster); err != nil {
+		return errors.Wrapf(err, "Failed to update node inventory of cluster [%s]", cluster.Name)
+	}
+	return nil
+}
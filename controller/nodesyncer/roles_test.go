@@ -0,0 +1,91 @@
+package nodesyncer
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetNodeRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		taints []corev1.Taint
+		want   []string
+	}{
+		{
+			name: "nothing known runs workloads",
+			want: []string{roleWorker},
+		},
+		{
+			name:   "rke",
+			labels: map[string]string{"node-role.kubernetes.io/etcd": "true", "node-role.kubernetes.io/controlplane": "true"},
+			want:   []string{roleEtcd, roleControlPlane},
+		},
+		{
+			name:   "kubeadm master",
+			labels: map[string]string{"node-role.kubernetes.io/master": ""},
+			want:   []string{roleControlPlane},
+		},
+		{
+			name:   "kops node",
+			labels: map[string]string{"kubernetes.io/role": "node"},
+			want:   []string{roleWorker},
+		},
+		{
+			name:   "kops master",
+			labels: map[string]string{"kubernetes.io/role": "master"},
+			want:   []string{roleControlPlane},
+		},
+		{
+			name:   "taint only",
+			taints: []corev1.Taint{{Key: "node-role.kubernetes.io/etcd", Effect: corev1.TaintEffectNoExecute}},
+			want:   []string{roleEtcd},
+		},
+		{
+			name:   "unrelated taint",
+			taints: []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}},
+			want:   []string{roleWorker},
+		},
+		{
+			name: "all roles",
+			labels: map[string]string{
+				"node-role.kubernetes.io/etcd":         "true",
+				"node-role.kubernetes.io/controlplane": "true",
+				"node-role.kubernetes.io/worker":       "true",
+			},
+			want: []string{roleEtcd, roleControlPlane, roleWorker},
+		},
+	}
+
+	for _, test := range tests {
+		node := &corev1.Node{}
+		node.Labels = test.labels
+		node.Spec.Taints = test.taints
+		if got := getNodeRoles(node); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetNodeInventory(t *testing.T) {
+	node := func(osImage, kubelet string) *corev1.Node {
+		node := &corev1.Node{}
+		node.Status.NodeInfo.OSImage = osImage
+		node.Status.NodeInfo.KubeletVersion = kubelet
+		return node
+	}
+
+	inventory := getNodeInventory([]*corev1.Node{
+		node("Ubuntu 16.04", "v1.8.5"),
+		node("Ubuntu 16.04", "v1.9.1"),
+		node("RancherOS", "v1.9.1"),
+	})
+	if want := map[string]int{"Ubuntu 16.04": 2, "RancherOS": 1}; !reflect.DeepEqual(inventory.OSImage, want) {
+		t.Errorf("got os images %v, want %v", inventory.OSImage, want)
+	}
+	if want := map[string]int{"v1.8.5": 1, "v1.9.1": 2}; !reflect.DeepEqual(inventory.KubeletVersion, want) {
+		t.Errorf("got kubelet versions %v, want %v", inventory.KubeletVersion, want)
+	}
+}
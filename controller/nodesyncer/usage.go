@@ -0,0 +1,238 @@
+package nodesyncer
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	nodeMetricsPath = "/apis/metrics.k8s.io/v1beta1/nodes"
+	podMetricsPath  = "/apis/metrics.k8s.io/v1beta1/pods"
+	// usageAnnotation holds the json encoded machineUsage of the Machine's node
+	usageAnnotation = "machine.cluster.cattle.io/usage"
+	// usageMaxFailures is how many refreshes in a row can fail before the last known usage is dropped,
+	// so Machines don't report the usage of a metrics API that is gone for good
+	usageMaxFailures = 3
+	// the usage is rounded to these steps, so the small changes between two refreshes don't update Machines
+	usageCPUStepMilli   = 50
+	usageMemoryStep     = 64 * 1024 * 1024
+	usagePercentageStep = 5
+)
+
+// UsageSyncer periodically reads node and pod usage from the metrics API,
+// and triggers Machine reconcile when it changes
+type UsageSyncer struct {
+	sync.RWMutex
+	clusterNamespace string
+	machines         v3.MachineInterface
+	podLister        v1.PodLister
+	restClient       rest.Interface
+	usage            map[string]machineUsage
+	available        bool
+	failures         int
+}
+
+type machineUsage struct {
+	// Node is the usage of the node as a whole
+	Node corev1.ResourceList `json:"node,omitempty"`
+	// Pods is the sum of the usage of all pods scheduled on the node
+	Pods corev1.ResourceList `json:"pods,omitempty"`
+	// Percentage of the node allocatable used
+	Percentage map[corev1.ResourceName]int64 `json:"percentage,omitempty"`
+}
+
+type nodeMetricsList struct {
+	Items []nodeMetrics `json:"items"`
+}
+
+type nodeMetrics struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Usage             corev1.ResourceList `json:"usage"`
+}
+
+type podMetricsList struct {
+	Items []podMetrics `json:"items"`
+}
+
+type podMetrics struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Containers        []containerMetrics `json:"containers"`
+}
+
+type containerMetrics struct {
+	Name  string              `json:"name"`
+	Usage corev1.ResourceList `json:"usage"`
+}
+
+func (u *UsageSyncer) syncUsage(ctx context.Context, refreshInterval time.Duration) {
+	for range utils.TickerContext(ctx, refreshInterval) {
+		if err := u.refresh(); err != nil {
+			// only the first failure in a row is worth reporting, the metrics API may stay unhealthy
+			if u.failures == 1 {
+				logrus.Infof("Failed to read node usage from metrics API: %v", err)
+			} else {
+				logrus.Debugf("Failed to read node usage from metrics API: %v", err)
+			}
+		}
+	}
+}
+
+func (u *UsageSyncer) refresh() error {
+	usage, err := u.read()
+	if err != nil {
+		u.failures++
+		if u.failures == usageMaxFailures {
+			logrus.Warnf("Failed to read node usage of cluster [%s] %d times in a row, dropping the last known usage", u.clusterNamespace, u.failures)
+			u.setUsage(nil, false)
+		}
+		return err
+	}
+	if u.failures > 0 {
+		logrus.Infof("Reading node usage from metrics API of cluster [%s] recovered", u.clusterNamespace)
+	}
+	u.failures = 0
+	u.setUsage(usage, usage != nil)
+	return nil
+}
+
+// read returns the usage of the nodes, nil when the metrics API isn't installed in the cluster
+func (u *UsageSyncer) read() (map[string]machineUsage, error) {
+	nodes := &nodeMetricsList{}
+	if err := u.get(nodeMetricsPath, nodes); err != nil {
+		if apierrors.IsNotFound(err) {
+			// metrics API isn't installed in the cluster, nothing to report
+			return nil, nil
+		}
+		return nil, err
+	}
+	pods := &podMetricsList{}
+	if err := u.get(podMetricsPath, pods); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	usage := map[string]machineUsage{}
+	for _, node := range nodes.Items {
+		usage[node.Name] = machineUsage{
+			Node: roundUsage(node.Usage),
+			Pods: corev1.ResourceList{},
+		}
+	}
+	for _, pod := range pods.Items {
+		cached, err := u.podLister.Get(pod.Namespace, pod.Name)
+		if err != nil || cached.Spec.NodeName == "" {
+			continue
+		}
+		nodeUsage, ok := usage[cached.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		for _, container := range pod.Containers {
+			addMap(container.Usage, nodeUsage.Pods)
+		}
+	}
+	for name, nodeUsage := range usage {
+		nodeUsage.Pods = roundUsage(nodeUsage.Pods)
+		usage[name] = nodeUsage
+	}
+	return usage, nil
+}
+
+// roundUsage rounds the cpu and memory usage to their steps
+func roundUsage(usage corev1.ResourceList) corev1.ResourceList {
+	rounded := corev1.ResourceList{}
+	for name, quantity := range usage {
+		switch name {
+		case corev1.ResourceCPU:
+			rounded[name] = *resource.NewMilliQuantity(roundTo(quantity.MilliValue(), usageCPUStepMilli), resource.DecimalSI)
+		case corev1.ResourceMemory:
+			rounded[name] = *resource.NewQuantity(roundTo(quantity.Value(), usageMemoryStep), resource.BinarySI)
+		default:
+			rounded[name] = quantity
+		}
+	}
+	return rounded
+}
+
+func roundTo(value, step int64) int64 {
+	return (value + step/2) / step * step
+}
+
+func (u *UsageSyncer) get(path string, into interface{}) error {
+	data, err := u.restClient.Get().AbsPath(path).DoRaw()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+func (u *UsageSyncer) setUsage(usage map[string]machineUsage, available bool) {
+	u.Lock()
+	changed := !reflect.DeepEqual(u.usage, usage)
+	if available != u.available {
+		logrus.Infof("Metrics API available for cluster [%s]: %v", u.clusterNamespace, available)
+	}
+	u.usage = usage
+	u.available = available
+	u.Unlock()
+
+	if changed {
+		u.machines.Controller().Enqueue(u.clusterNamespace, allMachineKey)
+	}
+}
+
+// getUsage returns the last known usage of the node, nil if it is unknown
+func (u *UsageSyncer) getUsage(node *corev1.Node) *machineUsage {
+	if u == nil {
+		return nil
+	}
+	u.RLock()
+	usage, ok := u.usage[node.Name]
+	u.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	usage.Percentage = map[corev1.ResourceName]int64{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		used, ok := usage.Node[name]
+		allocatable, hasAllocatable := node.Status.Allocatable[name]
+		if !ok || !hasAllocatable || allocatable.IsZero() {
+			continue
+		}
+		usage.Percentage[name] = percentage(used, allocatable)
+	}
+	return &usage
+}
+
+func percentage(used, total resource.Quantity) int64 {
+	return roundTo(used.MilliValue()*100/total.MilliValue(), usagePercentageStep)
+}
+
+func setUsageAnnotation(machine *v3.Machine, usage *machineUsage) {
+	if usage == nil {
+		delete(machine.Annotations, usageAnnotation)
+		return
+	}
+	data, err := json.Marshal(usage)
+	if err != nil {
+		logrus.Warnf("Failed to encode usage of machine [%s]: %v", machine.Name, err)
+		return
+	}
+	if machine.Annotations == nil {
+		machine.Annotations = map[string]string{}
+	}
+	machine.Annotations[usageAnnotation] = string(data)
+}
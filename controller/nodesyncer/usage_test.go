@@ -0,0 +1,173 @@
+package nodesyncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/types/apis/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	nodeMetricsJSON = `{"items": [{"metadata": {"name": "node-1"}, "usage": {"cpu": "500m", "memory": "1Gi"}}]}`
+	podMetricsJSON  = `{"items": [{"metadata": {"name": "pod-1", "namespace": "default"},
+		"containers": [{"name": "a", "usage": {"cpu": "100m", "memory": "128Mi"}}, {"name": "b", "usage": {"cpu": "50m", "memory": "128Mi"}}]}]}`
+)
+
+type fakePods struct {
+	v1.PodLister
+	pods map[string]*corev1.Pod
+}
+
+func (f *fakePods) Get(namespace, name string) (*corev1.Pod, error) {
+	if pod, ok := f.pods[namespace+"/"+name]; ok {
+		return pod, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name)
+}
+
+func (f *fakePods) List(namespace string, selector labels.Selector) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	for _, pod := range f.pods {
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// metricsServer serves the metrics API with the status code of the test
+type metricsServer struct {
+	status int
+}
+
+func (m *metricsServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if m.status != http.StatusOK {
+		rw.WriteHeader(m.status)
+		fmt.Fprintf(rw, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "code": %d}`, m.status)
+		return
+	}
+	switch req.URL.Path {
+	case nodeMetricsPath:
+		fmt.Fprint(rw, nodeMetricsJSON)
+	case podMetricsPath:
+		fmt.Fprint(rw, podMetricsJSON)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newUsageSyncer(t *testing.T, url string) (*UsageSyncer, *fakeMachines) {
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"}}
+	pod.Spec.NodeName = "node-1"
+	machines := &fakeMachines{}
+	return &UsageSyncer{
+		clusterNamespace: "c-1",
+		machines:         machines,
+		podLister:        &fakePods{pods: map[string]*corev1.Pod{"default/pod-1": pod}},
+		restClient:       client.Discovery().RESTClient(),
+	}, machines
+}
+
+func TestRefresh(t *testing.T) {
+	metrics := &metricsServer{status: http.StatusOK}
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	u, machines := newUsageSyncer(t, server.URL)
+
+	if err := u.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	node.Status.Allocatable = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	}
+	usage := u.getUsage(node)
+	if usage == nil {
+		t.Fatal("no usage for node-1")
+	}
+	if cpu := usage.Pods[corev1.ResourceCPU]; cpu.MilliValue() != 150 {
+		t.Errorf("got pods cpu %s, want 150m", cpu.String())
+	}
+	if memory := usage.Pods[corev1.ResourceMemory]; memory.Value() != 256*1024*1024 {
+		t.Errorf("got pods memory %s, want 256Mi", memory.String())
+	}
+	if got := usage.Percentage[corev1.ResourceCPU]; got != 25 {
+		t.Errorf("got cpu percentage %d, want 25", got)
+	}
+	if got := usage.Percentage[corev1.ResourceMemory]; got != 25 {
+		t.Errorf("got memory percentage %d, want 25", got)
+	}
+	if machines.controller.enqueued != 1 {
+		t.Errorf("got %d machine reconciles, want 1", machines.controller.enqueued)
+	}
+
+	// unchanged usage doesn't reconcile machines
+	if err := u.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if machines.controller.enqueued != 1 {
+		t.Errorf("got %d machine reconciles for unchanged usage, want 1", machines.controller.enqueued)
+	}
+
+	// the last known usage is kept until the metrics API failed usageMaxFailures times in a row
+	metrics.status = http.StatusServiceUnavailable
+	for i := 1; i <= usageMaxFailures; i++ {
+		if err := u.refresh(); err == nil {
+			t.Fatalf("refresh %d: no error from an unavailable metrics API", i)
+		}
+		if kept := u.getUsage(node) != nil; kept != (i < usageMaxFailures) {
+			t.Errorf("refresh %d: got usage kept %v", i, kept)
+		}
+	}
+
+	metrics.status = http.StatusOK
+	if err := u.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if u.getUsage(node) == nil || !u.available || u.failures != 0 {
+		t.Error("usage not reported again once the metrics API is back")
+	}
+
+	// metrics API removed from the cluster
+	metrics.status = http.StatusNotFound
+	if err := u.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if u.getUsage(node) != nil || u.available {
+		t.Error("usage reported without a metrics API")
+	}
+}
+
+func TestRoundUsage(t *testing.T) {
+	usage := roundUsage(corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("512m"),
+		corev1.ResourceMemory: resource.MustParse("1050Mi"),
+		corev1.ResourcePods:   resource.MustParse("3"),
+	})
+	if cpu := usage[corev1.ResourceCPU]; cpu.MilliValue() != 500 {
+		t.Errorf("got cpu %s, want 500m", cpu.String())
+	}
+	if memory := usage[corev1.ResourceMemory]; memory.Value() != 1024*1024*1024 {
+		t.Errorf("got memory %s, want 1Gi", memory.String())
+	}
+	if pods := usage[corev1.ResourcePods]; pods.Value() != 3 {
+		t.Errorf("got pods %s, want 3", pods.String())
+	}
+	if got := percentage(resource.MustParse("530m"), resource.MustParse("2")); got != 25 {
+		t.Errorf("got percentage %d, want 25", got)
+	}
+}
@@ -0,0 +1,191 @@
+package projectcopy
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// The objects of the project namespaces in management, secrets and config maps, are copied to the
+// namespaces of the project in the cluster. This is what the controllers copying them share
+
+const (
+	// ProjectIDAnnotation is set on a namespace of a project, its value is <cluster name>:<project name>
+	ProjectIDAnnotation = "field.cattle.io/projectId"
+	// ProjectNamespaceAnnotation marks the namespace of a project in management
+	ProjectNamespaceAnnotation = "management.cattle.io/system-namespace"
+)
+
+// Object is a copied object, a *corev1.Secret or a *corev1.ConfigMap
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Store reads the copies of a kind from the cache of the cluster and writes them to the cluster
+type Store interface {
+	Get(namespace, name string) (Object, error)
+	List(namespace string) ([]Object, error)
+	// GetLive reads the object from the cluster, for when the cache is behind
+	GetLive(namespace, name string) (Object, error)
+	Create(obj Object) (Object, error)
+	Update(obj Object) (Object, error)
+	Delete(namespace, name string) error
+}
+
+// Copier writes and deletes the copies of a kind
+type Copier struct {
+	// Kind names the copies in logs and events, in lower case
+	Kind  string
+	Store Store
+	// SourceProjectLabel records on a copy the project it was copied from
+	SourceProjectLabel string
+	// CarryOver, if set, copies onto an update of a copy what it keeps from the copy it replaces
+	CarryOver func(copy, existing Object)
+	Events    record.EventRecorder
+
+	ReasonRemoved           string
+	ReasonPropagationFailed string
+	ReasonConflict          string
+}
+
+// ProjectName returns the name of the project of the namespace, empty if it isn't in a project
+func ProjectName(namespace *corev1.Namespace) string {
+	// field.cattle.io/projectId value is <cluster name>:<project name>
+	if parts := strings.Split(namespace.Annotations[ProjectIDAnnotation], ":"); len(parts) == 2 {
+		return parts[1]
+	}
+	return ""
+}
+
+// ProjectNamespaces returns the namespaces of the cluster in the project of the management namespace,
+// none if it isn't the namespace of a project of the cluster
+func ProjectNamespaces(clusterName, projectNamespaceName string, managementNamespaces, clusterNamespaces v1.NamespaceLister, projects v3.ProjectLister) ([]*corev1.Namespace, error) {
+	projectNamespace, err := managementNamespaces.Get("", projectNamespaceName)
+	if errors.IsNotFound(err) {
+		logrus.Warnf("Project namespace [%s] can't be found", projectNamespaceName)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if projectNamespace.Annotations[ProjectNamespaceAnnotation] != "true" {
+		return nil, nil
+	}
+
+	// Ignore projects from other clusters. Project namespace name = project name, so use it to locate the project
+	if _, err := projects.Get(clusterName, projectNamespace.Name); errors.IsNotFound(err) {
+		logrus.Warnf("Project [%s] can't be found", projectNamespace.Name)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := clusterNamespaces.List("", labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	var toReturn []*corev1.Namespace
+	for _, namespace := range namespaces {
+		// system project namespace name == project.Name
+		if ProjectName(namespace) == projectNamespace.Name {
+			toReturn = append(toReturn, namespace)
+		}
+	}
+	return toReturn, nil
+}
+
+// HashData writes the data to the hash, sorted by key
+func HashData(hash io.Writer, data map[string][]byte) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(data[key])
+		hash.Write([]byte{0})
+	}
+}
+
+// EnsureCopy creates or updates the copy of the source in the namespace, and returns it unless it was
+// already up to date, with the copy it replaced if any. An object of the same name the agent doesn't
+// own is never overwritten
+func (c *Copier) EnsureCopy(source Object, namespace *corev1.Namespace, copy Object, owned, upToDate func(existing Object) bool) (written, existing Object, err error) {
+	existing, err = c.Store.Get(namespace.Name, copy.GetName())
+	if errors.IsNotFound(err) {
+		logrus.Infof("Copying %s [%s] into namespace [%s]", c.Kind, copy.GetName(), namespace.Name)
+		created, createErr := c.Store.Create(copy)
+		if createErr == nil {
+			return created, nil, nil
+		}
+		if !errors.IsAlreadyExists(createErr) {
+			return nil, nil, createErr
+		}
+		// the cache is behind, compare against the actual object
+		existing, err = c.Store.GetLive(namespace.Name, copy.GetName())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !owned(existing) {
+		logrus.Warnf("Not copying %s [%s] into namespace [%s], a %s of the same name exists", c.Kind, copy.GetName(), namespace.Name, c.Kind)
+		c.Events.Eventf(namespace, corev1.EventTypeWarning, c.ReasonConflict, "%s [%s] of project [%s] not copied, a %s of the same name exists",
+			capitalize(c.Kind), source.GetName(), source.GetNamespace(), c.Kind)
+		return nil, existing, nil
+	}
+	if upToDate(existing) {
+		return nil, existing, nil
+	}
+
+	logrus.Infof("Updating %s [%s] in namespace [%s]", c.Kind, copy.GetName(), namespace.Name)
+	copy.SetResourceVersion(existing.GetResourceVersion())
+	if c.CarryOver != nil {
+		c.CarryOver(copy, existing)
+	}
+	updated, err := c.Store.Update(copy)
+	if err != nil {
+		return nil, existing, err
+	}
+	return updated, existing, nil
+}
+
+// RemoveOtherProjectCopies deletes the copies in the namespace made from projects it is no longer in
+func (c *Copier) RemoveOtherProjectCopies(namespace *corev1.Namespace, projectName string) error {
+	copies, err := c.Store.List(namespace.Name)
+	if err != nil {
+		return err
+	}
+	for _, copy := range copies {
+		sourceProject := copy.GetLabels()[c.SourceProjectLabel]
+		if sourceProject == "" || sourceProject == projectName {
+			continue
+		}
+		logrus.Infof("Deleting %s [%s] of project [%s] from namespace [%s]", c.Kind, copy.GetName(), sourceProject, namespace.Name)
+		if err := c.Store.Delete(namespace.Name, copy.GetName()); err != nil && !errors.IsNotFound(err) {
+			c.Events.Eventf(namespace, corev1.EventTypeWarning, c.ReasonPropagationFailed, "Failed to delete %s [%s] of project [%s]: %v", c.Kind, copy.GetName(), sourceProject, err)
+			return err
+		}
+		c.Events.Eventf(namespace, corev1.EventTypeNormal, c.ReasonRemoved, "Removed %s [%s] of project [%s], the namespace left the project", c.Kind, copy.GetName(), sourceProject)
+	}
+	return nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,221 @@
+package projectcopy
+
+import (
+	"bytes"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+const sourceProjectLabel = "test.cattle.io/source-project"
+
+var resource = schema.GroupResource{Resource: "configmaps"}
+
+// fakeStore holds the cached copies, and the live ones the cache may be behind on
+type fakeStore struct {
+	cached  map[string]Object
+	live    map[string]Object
+	created []string
+	updated []string
+	deleted []string
+}
+
+func newFakeStore(cached, live []Object) *fakeStore {
+	s := &fakeStore{cached: map[string]Object{}, live: map[string]Object{}}
+	for _, obj := range cached {
+		s.cached[obj.GetNamespace()+"/"+obj.GetName()] = obj
+		s.live[obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	for _, obj := range live {
+		s.live[obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	return s
+}
+
+func (s *fakeStore) Get(namespace, name string) (Object, error) {
+	if obj, ok := s.cached[namespace+"/"+name]; ok {
+		return obj, nil
+	}
+	return nil, errors.NewNotFound(resource, name)
+}
+
+func (s *fakeStore) List(namespace string) ([]Object, error) {
+	var objs []Object
+	for _, obj := range s.cached {
+		if obj.GetNamespace() == namespace {
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+func (s *fakeStore) GetLive(namespace, name string) (Object, error) {
+	if obj, ok := s.live[namespace+"/"+name]; ok {
+		return obj, nil
+	}
+	return nil, errors.NewNotFound(resource, name)
+}
+
+func (s *fakeStore) Create(obj Object) (Object, error) {
+	if _, ok := s.live[obj.GetNamespace()+"/"+obj.GetName()]; ok {
+		return nil, errors.NewAlreadyExists(resource, obj.GetName())
+	}
+	s.created = append(s.created, obj.GetNamespace()+"/"+obj.GetName())
+	return obj, nil
+}
+
+func (s *fakeStore) Update(obj Object) (Object, error) {
+	s.updated = append(s.updated, obj.GetNamespace()+"/"+obj.GetName()+"@"+obj.GetResourceVersion())
+	return obj, nil
+}
+
+func (s *fakeStore) Delete(namespace, name string) error {
+	s.deleted = append(s.deleted, namespace+"/"+name)
+	return nil
+}
+
+func newConfigMap(namespace, name, resourceVersion string, labels map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       namespace,
+			Name:            name,
+			ResourceVersion: resourceVersion,
+			Labels:          labels,
+		},
+	}
+}
+
+func TestEnsureCopy(t *testing.T) {
+	source := newConfigMap("p-abc", "config", "", nil)
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	owned := map[string]string{sourceProjectLabel: "p-abc"}
+
+	tests := []struct {
+		name         string
+		cached       []Object
+		live         []Object
+		upToDate     bool
+		wantWritten  bool
+		wantExisting bool
+		wantCreated  []string
+		wantUpdated  []string
+	}{
+		{
+			name:        "missing copy is created",
+			wantWritten: true,
+			wantCreated: []string{"app/config"},
+		},
+		{
+			name:         "outdated copy is updated",
+			cached:       []Object{newConfigMap("app", "config", "5", owned)},
+			wantWritten:  true,
+			wantExisting: true,
+			wantUpdated:  []string{"app/config@5"},
+		},
+		{
+			name:         "up to date copy is left alone",
+			cached:       []Object{newConfigMap("app", "config", "5", owned)},
+			upToDate:     true,
+			wantExisting: true,
+		},
+		{
+			name:         "object of the same name is not overwritten",
+			cached:       []Object{newConfigMap("app", "config", "5", nil)},
+			wantExisting: true,
+		},
+		{
+			name:         "copy missing from the cache is compared against the live one",
+			live:         []Object{newConfigMap("app", "config", "7", owned)},
+			wantWritten:  true,
+			wantExisting: true,
+			wantUpdated:  []string{"app/config@7"},
+		},
+	}
+
+	for _, test := range tests {
+		store := newFakeStore(test.cached, test.live)
+		c := &Copier{
+			Kind:               "config map",
+			Store:              store,
+			SourceProjectLabel: sourceProjectLabel,
+			Events:             record.NewFakeRecorder(10),
+		}
+		written, existing, err := c.EnsureCopy(source, namespace, newConfigMap("app", "config", "", owned),
+			func(existing Object) bool {
+				return existing.GetLabels()[sourceProjectLabel] == source.Namespace
+			},
+			func(existing Object) bool {
+				return test.upToDate
+			})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if (written != nil) != test.wantWritten {
+			t.Errorf("%s: got written %v, want written %v", test.name, written, test.wantWritten)
+		}
+		if (existing != nil) != test.wantExisting {
+			t.Errorf("%s: got existing %v, want existing %v", test.name, existing, test.wantExisting)
+		}
+		if !equal(store.created, test.wantCreated) {
+			t.Errorf("%s: got created %v, want %v", test.name, store.created, test.wantCreated)
+		}
+		if !equal(store.updated, test.wantUpdated) {
+			t.Errorf("%s: got updated %v, want %v", test.name, store.updated, test.wantUpdated)
+		}
+	}
+}
+
+func TestRemoveOtherProjectCopies(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	store := newFakeStore([]Object{
+		newConfigMap("app", "current", "", map[string]string{sourceProjectLabel: "p-abc"}),
+		newConfigMap("app", "previous", "", map[string]string{sourceProjectLabel: "p-old"}),
+		newConfigMap("app", "own", "", nil),
+		newConfigMap("other", "previous", "", map[string]string{sourceProjectLabel: "p-old"}),
+	}, nil)
+	c := &Copier{
+		Kind:               "config map",
+		Store:              store,
+		SourceProjectLabel: sourceProjectLabel,
+		Events:             record.NewFakeRecorder(10),
+	}
+
+	if err := c.RemoveOtherProjectCopies(namespace, "p-abc"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"app/previous"}; !equal(store.deleted, want) {
+		t.Errorf("got deleted %v, want %v", store.deleted, want)
+	}
+}
+
+func TestHashData(t *testing.T) {
+	hash := func(data map[string][]byte) string {
+		var b bytes.Buffer
+		HashData(&b, data)
+		return b.String()
+	}
+	// the separators keep a key from running into its value
+	if hash(map[string][]byte{"ab": []byte("c")}) == hash(map[string][]byte{"a": []byte("bc")}) {
+		t.Error("hashes of different data are equal")
+	}
+	if hash(map[string][]byte{"a": []byte("1"), "b": []byte("2")}) != hash(map[string][]byte{"b": []byte("2"), "a": []byte("1")}) {
+		t.Error("hashes of equal data are different")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
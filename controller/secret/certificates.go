@@ -0,0 +1,307 @@
+package secret
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	projectv3 "github.com/rancher/types/apis/project.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// certificateConditionsAnnotation holds the conditions of a Certificate, which has no status
+	certificateConditionsAnnotation = "certificate.cluster.cattle.io/conditions"
+	// expiringSoonThreshold is how long before expiry a certificate is reported as expiring soon
+	expiringSoonThreshold = 30 * 24 * time.Hour
+
+	certificateConditionValid        = "Valid"
+	certificateConditionExpiringSoon = "ExpiringSoon"
+
+	reasonInvalidCertificate = "InvalidCertificate"
+	reasonKeyMismatch        = "KeyMismatch"
+	reasonExpired            = "Expired"
+)
+
+// CertificateCondition is the condition of a Certificate, stored as JSON in its annotations
+type CertificateCondition struct {
+	Type    string                 `json:"type"`
+	Status  corev1.ConditionStatus `json:"status"`
+	Reason  string                 `json:"reason,omitempty"`
+	Message string                 `json:"message,omitempty"`
+}
+
+// CertificateController fills the read-only fields of Certificates from their PEM data, and reports
+// whether the key matches and the certificate expires soon. Certificates are enqueued when they reach
+// the expiring soon threshold and when they expire, so the condition changes on time
+type CertificateController struct {
+	management projectv3.CertificateInterface
+	cluster    projectv3.NamespacedCertificateInterface
+
+	lock sync.Mutex
+	// scheduled holds when each certificate is enqueued next, by kind and key
+	scheduled map[string]time.Time
+}
+
+func registerCertificates(clusterName string, management, cluster projectv3.Interface) {
+	c := &CertificateController{
+		management: management.Certificates(""),
+		scheduled:  map[string]time.Time{},
+	}
+	c.management.AddClusterScopedHandler("certificatesController", clusterName, c.sync)
+	// nil when the cluster doesn't serve namespaced credentials
+	if cluster == nil {
+		return
+	}
+	c.cluster = cluster.NamespacedCertificates("")
+	c.cluster.AddHandler("certificatesController", c.syncNamespaced)
+}
+
+func (c *CertificateController) sync(key string, obj *projectv3.Certificate) error {
+	if obj == nil || obj.DeletionTimestamp != nil {
+		c.forget(certificateKind + "/" + key)
+		return nil
+	}
+	now := time.Now()
+	c.enqueueNextCheck(certificateKind+"/"+key, obj, now, c.management.Controller().Enqueue)
+	updated := describeCertificate(obj, now)
+	if updated == nil {
+		return nil
+	}
+	_, err := c.management.Update(updated)
+	return err
+}
+
+func (c *CertificateController) syncNamespaced(key string, obj *projectv3.NamespacedCertificate) error {
+	if obj == nil || obj.DeletionTimestamp != nil {
+		c.forget(namespacedCertificateKind + "/" + key)
+		return nil
+	}
+	now := time.Now()
+	c.enqueueNextCheck(namespacedCertificateKind+"/"+key, (*projectv3.Certificate)(obj), now, c.cluster.Controller().Enqueue)
+	updated := describeCertificate((*projectv3.Certificate)(obj), now)
+	if updated == nil {
+		return nil
+	}
+	_, err := c.cluster.Update((*projectv3.NamespacedCertificate)(updated))
+	return err
+}
+
+// enqueueNextCheck enqueues the certificate when its expiring soon condition changes next, unless it
+// is already scheduled then
+func (c *CertificateController) enqueueNextCheck(scheduleKey string, obj *projectv3.Certificate, now time.Time, enqueue func(namespace, name string)) {
+	next, ok := nextCertificateCheck(obj.Certs, now)
+	if !ok {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.scheduled[scheduleKey].Equal(next) {
+		return
+	}
+	c.scheduled[scheduleKey] = next
+	namespace, name := obj.Namespace, obj.Name
+	time.AfterFunc(next.Sub(now), func() {
+		c.lock.Lock()
+		if c.scheduled[scheduleKey].Equal(next) {
+			delete(c.scheduled, scheduleKey)
+		}
+		c.lock.Unlock()
+		enqueue(namespace, name)
+	})
+}
+
+func (c *CertificateController) forget(scheduleKey string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.scheduled, scheduleKey)
+}
+
+// nextCertificateCheck returns when the expiring soon condition of the certificate changes next, just
+// past the threshold or the expiry, and false when it won't change anymore
+func nextCertificateCheck(certs string, now time.Time) (time.Time, bool) {
+	leaf, err := parseCertificates(certs)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, at := range []time.Time{leaf.NotAfter.Add(-expiringSoonThreshold), leaf.NotAfter} {
+		if at = at.Add(time.Second); now.Before(at) {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// describeCertificate returns a copy of the Certificate with its read-only fields and conditions set,
+// or nil when they are already up to date
+func describeCertificate(obj *projectv3.Certificate, now time.Time) *projectv3.Certificate {
+	updated := obj.DeepCopy()
+	var conditions []CertificateCondition
+
+	leaf, err := parseCertificates(obj.Certs)
+	if err != nil {
+		clearCertificateFields(updated)
+		conditions = append(conditions, CertificateCondition{
+			Type:    certificateConditionValid,
+			Status:  corev1.ConditionFalse,
+			Reason:  reasonInvalidCertificate,
+			Message: err.Error(),
+		})
+	} else {
+		setCertificateFields(updated, leaf)
+		conditions = append(conditions, validCondition(obj), expiringSoonCondition(leaf, now))
+	}
+
+	data, err := json.Marshal(conditions)
+	if err != nil {
+		logrus.Errorf("Failed to encode conditions of certificate [%s]: %v", obj.Name, err)
+		return nil
+	}
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[certificateConditionsAnnotation] = string(data)
+
+	if reflect.DeepEqual(obj, updated) {
+		return nil
+	}
+	return updated
+}
+
+// parseCertificates parses the PEM chain and returns its first certificate, the one the key is for
+func parseCertificates(certs string) (*x509.Certificate, error) {
+	var leaf *x509.Certificate
+	rest := []byte(certs)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		if leaf == nil {
+			leaf = cert
+		}
+	}
+	if leaf == nil {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return leaf, nil
+}
+
+func setCertificateFields(obj *projectv3.Certificate, cert *x509.Certificate) {
+	obj.CertFingerprint = fingerprint(cert.Raw)
+	obj.CN = cert.Subject.CommonName
+	obj.Version = strconv.Itoa(cert.Version)
+	obj.ExpiresAt = cert.NotAfter.UTC().Format(time.RFC3339)
+	obj.IssuedAt = cert.NotBefore.UTC().Format(time.RFC3339)
+	obj.Issuer = cert.Issuer.String()
+	// the signature algorithm, the key is described by its size
+	obj.Algorithm = cert.SignatureAlgorithm.String()
+	obj.SerialNumber = cert.SerialNumber.String()
+	obj.KeySize = keySize(cert)
+
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	obj.SubjectAlternativeNames = names
+}
+
+func clearCertificateFields(obj *projectv3.Certificate) {
+	obj.CertFingerprint = ""
+	obj.CN = ""
+	obj.Version = ""
+	obj.ExpiresAt = ""
+	obj.IssuedAt = ""
+	obj.Issuer = ""
+	obj.Algorithm = ""
+	obj.SerialNumber = ""
+	obj.KeySize = ""
+	obj.SubjectAlternativeNames = nil
+}
+
+// fingerprint returns the SHA1 fingerprint of the certificate in the colon separated form
+func fingerprint(der []byte) string {
+	sum := sha1.Sum(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func keySize(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return strconv.Itoa(key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return strconv.Itoa(key.Curve.Params().BitSize)
+	}
+	return ""
+}
+
+// validCondition reports whether the key matches the certificate. The key is write only in the API
+// but stored, a Certificate without one is left Unknown
+func validCondition(obj *projectv3.Certificate) CertificateCondition {
+	if obj.Key == "" {
+		return CertificateCondition{
+			Type:    certificateConditionValid,
+			Status:  corev1.ConditionUnknown,
+			Message: "no key to validate the certificate against",
+		}
+	}
+	if _, err := tls.X509KeyPair([]byte(obj.Certs), []byte(obj.Key)); err != nil {
+		return CertificateCondition{
+			Type:    certificateConditionValid,
+			Status:  corev1.ConditionFalse,
+			Reason:  reasonKeyMismatch,
+			Message: err.Error(),
+		}
+	}
+	return CertificateCondition{
+		Type:   certificateConditionValid,
+		Status: corev1.ConditionTrue,
+	}
+}
+
+func expiringSoonCondition(cert *x509.Certificate, now time.Time) CertificateCondition {
+	switch {
+	case !now.Before(cert.NotAfter):
+		return CertificateCondition{
+			Type:    certificateConditionExpiringSoon,
+			Status:  corev1.ConditionTrue,
+			Reason:  reasonExpired,
+			Message: fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339)),
+		}
+	case cert.NotAfter.Sub(now) < expiringSoonThreshold:
+		return CertificateCondition{
+			Type:    certificateConditionExpiringSoon,
+			Status:  corev1.ConditionTrue,
+			Message: fmt.Sprintf("certificate expires at %s", cert.NotAfter.UTC().Format(time.RFC3339)),
+		}
+	}
+	return CertificateCondition{
+		Type:   certificateConditionExpiringSoon,
+		Status: corev1.ConditionFalse,
+	}
+}
@@ -0,0 +1,92 @@
+package secret
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	projectv3 "github.com/rancher/types/apis/project.cattle.io/v3"
+)
+
+// newTestCertificate returns a PEM encoded self-signed certificate expiring at notAfter
+func newTestCertificate(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com", Organization: []string{"Example"}},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNextCertificateCheck(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		want     time.Time
+		wantOK   bool
+	}{
+		{name: "valid", notAfter: now.Add(60 * 24 * time.Hour), want: now.Add(30*24*time.Hour + time.Second), wantOK: true},
+		{name: "expiring soon", notAfter: now.Add(time.Hour), want: now.Add(time.Hour + time.Second), wantOK: true},
+		{name: "expired", notAfter: now.Add(-time.Hour)},
+	}
+
+	for _, test := range tests {
+		next, ok := nextCertificateCheck(newTestCertificate(t, test.notAfter), now)
+		if ok != test.wantOK || !next.Equal(test.want) {
+			t.Errorf("%s: got %v %v, want %v %v", test.name, next, ok, test.want, test.wantOK)
+		}
+		// the condition has changed when the certificate is enqueued
+		if ok {
+			before := expiringSoonCondition(mustParse(t, test.notAfter), now)
+			after := expiringSoonCondition(mustParse(t, test.notAfter), next)
+			if before == after {
+				t.Errorf("%s: condition %+v unchanged at the next check", test.name, after)
+			}
+		}
+	}
+
+	if _, ok := nextCertificateCheck("invalid", now); ok {
+		t.Error("got a next check for an invalid certificate")
+	}
+}
+
+func mustParse(t *testing.T, notAfter time.Time) *x509.Certificate {
+	leaf, err := parseCertificates(newTestCertificate(t, notAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestSetCertificateFields(t *testing.T) {
+	notAfter := time.Now().Add(60 * 24 * time.Hour).UTC().Truncate(time.Second)
+	obj := &projectv3.Certificate{}
+	setCertificateFields(obj, mustParse(t, notAfter))
+
+	if obj.Issuer != "CN=example.com,O=Example" {
+		t.Errorf("got issuer %q, want the full DN", obj.Issuer)
+	}
+	if obj.CN != "example.com" || obj.Algorithm != "ECDSA-SHA256" || obj.KeySize != "256" || obj.SerialNumber != "1" {
+		t.Errorf("got fields %+v", obj)
+	}
+	if obj.ExpiresAt != notAfter.Format(time.RFC3339) || len(obj.SubjectAlternativeNames) != 1 {
+		t.Errorf("got fields %+v", obj)
+	}
+}
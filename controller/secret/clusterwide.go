@@ -0,0 +1,172 @@
+package secret
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	// clusterWideLabel opts a secret in the management namespace of the cluster in to being copied to
+	// the namespaces of the cluster, when set to true
+	clusterWideLabel = "secret.cluster.cattle.io/cluster-wide"
+	// sourceClusterLabel records the cluster a cluster-wide secret was copied from
+	sourceClusterLabel = "secret.cluster.cattle.io/source-cluster"
+	// namespaceSelectorAnnotation limits a cluster-wide secret to the namespaces matching the label selector
+	namespaceSelectorAnnotation = "secret.cluster.cattle.io/namespace-selector"
+	// projectsAnnotation limits a cluster-wide secret to the namespaces of the comma separated projects
+	projectsAnnotation = "secret.cluster.cattle.io/projects"
+)
+
+// Secrets in the management namespace of the cluster, named after the cluster, labeled with
+// secret.cluster.cattle.io/cluster-wide=true are cluster-wide and copied to every namespace of the
+// cluster. The namespace selector and project list annotations limit the namespaces, a namespace has
+// to match both when both are set
+
+// inClusterNamespace returns whether the secret is a plain secret in the management namespace of the
+// cluster, the ones that can opt in to being cluster-wide
+func inClusterNamespace(obj *corev1.Secret, clusterName string) bool {
+	return obj.Namespace == clusterName && obj.Labels[sourceKindLabel] == ""
+}
+
+// isClusterWide returns whether the secret is in the management namespace of the cluster and opted in
+// to being copied to the namespaces of the cluster
+func isClusterWide(obj *corev1.Secret, clusterName string) bool {
+	return inClusterNamespace(obj, clusterName) && obj.Labels[clusterWideLabel] == "true"
+}
+
+// matchesClusterWide returns whether the cluster-wide secret is copied to the namespace
+func matchesClusterWide(obj *corev1.Secret, namespace *corev1.Namespace) (bool, error) {
+	if namespace.DeletionTimestamp != nil {
+		return false, nil
+	}
+	if value := obj.Annotations[namespaceSelectorAnnotation]; value != "" {
+		selector, err := labels.Parse(value)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid namespace selector of secret [%s]", obj.Name)
+		}
+		if !selector.Matches(labels.Set(namespace.Labels)) {
+			return false, nil
+		}
+	}
+	if value := obj.Annotations[projectsAnnotation]; value != "" {
+		// field.cattle.io/projectId value is <cluster name>:<project name>
+		parts := strings.Split(namespace.Annotations[projectIDLabel], ":")
+		if len(parts) != 2 {
+			return false, nil
+		}
+		for _, project := range strings.Split(value, ",") {
+			// projects are listed by name or by id
+			project = strings.TrimSpace(project)
+			if i := strings.Index(project, ":"); i >= 0 {
+				project = project[i+1:]
+			}
+			if project == parts[1] {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *Controller) getClusterWideNamespaces(obj *corev1.Secret) ([]*corev1.Namespace, error) {
+	namespaces, err := s.clusterNamespaceLister.List("", labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	var toReturn []*corev1.Namespace
+	for _, namespace := range namespaces {
+		matches, err := matchesClusterWide(obj, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			toReturn = append(toReturn, namespace)
+		}
+	}
+	return toReturn, nil
+}
+
+// removeClusterWideCopies deletes the copies of the cluster-wide secret outside of the namespaces
+func (s *Controller) removeClusterWideCopies(obj *corev1.Secret, namespaces []*corev1.Namespace) error {
+	requirement, err := labels.NewRequirement(sourceClusterLabel, selection.Equals, []string{s.clusterName})
+	if err != nil {
+		return err
+	}
+	secrets, err := s.clusterSecrets.List("", labels.NewSelector().Add(*requirement))
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, namespace := range namespaces {
+		keep[namespace.Name] = true
+	}
+	for _, secret := range secrets {
+		if secret.Name != obj.Name || keep[secret.Namespace] {
+			continue
+		}
+		if err := s.deleteCopy(secret); err != nil {
+			return err
+		}
+		s.events.Eventf(secret, corev1.EventTypeNormal, reasonRemoved, "Removed copy of cluster secret [%s], the namespace is no longer selected", secret.Name)
+	}
+	return nil
+}
+
+// syncClusterWide copies the cluster-wide secrets the namespace is selected by, and removes the
+// copies of the ones it no longer is
+func (n *NamespaceController) syncClusterWide(obj *corev1.Namespace) error {
+	secrets, err := n.managementSecrets.List(n.clusterName, labels.NewSelector())
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if secret.DeletionTimestamp != nil || !isClusterWide(secret, n.clusterName) {
+			continue
+		}
+		matches, err := matchesClusterWide(secret, obj)
+		if err != nil {
+			n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy cluster secret [%s]: %v", secret.Name, err)
+			continue
+		}
+
+		if matches {
+			decrypted, err := n.decrypt(secret)
+			if err != nil {
+				n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy cluster secret [%s]: %v", secret.Name, err)
+				return err
+			}
+			created, err := n.clusterSecretsClient.Create(copySecret(decrypted, obj.Name, n.clusterName))
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy cluster secret [%s]: %v", secret.Name, err)
+				return err
+			}
+			if err == nil {
+				n.events.Eventf(created, corev1.EventTypeNormal, reasonPropagated, "Copied from cluster [%s]", n.clusterName)
+			}
+			continue
+		}
+
+		existing, err := n.clusterSecrets.Get(obj.Name, secret.Name)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if existing.Labels[sourceClusterLabel] != n.clusterName {
+			continue
+		}
+		if err := n.clusterSecretsClient.DeleteNamespaced(obj.Name, secret.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		n.events.Eventf(obj, corev1.EventTypeNormal, reasonRemoved, "Removed cluster secret [%s], the namespace is no longer selected", secret.Name)
+	}
+	return nil
+}
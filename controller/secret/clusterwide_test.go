@@ -0,0 +1,26 @@
+package secret
+
+import (
+	"testing"
+)
+
+func TestIsClusterWide(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{name: "opted in", namespace: "c-1", labels: map[string]string{clusterWideLabel: "true"}, want: true},
+		{name: "not opted in", namespace: "c-1"},
+		{name: "opted out", namespace: "c-1", labels: map[string]string{clusterWideLabel: "false"}},
+		{name: "credential", namespace: "c-1", labels: map[string]string{clusterWideLabel: "true", sourceKindLabel: dockerCredentialKind}},
+		{name: "other namespace", namespace: "p-1", labels: map[string]string{clusterWideLabel: "true"}},
+	}
+
+	for _, test := range tests {
+		if got := isClusterWide(newSecret(test.namespace, "secret", test.labels), "c-1"); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
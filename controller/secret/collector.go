@@ -0,0 +1,105 @@
+package secret
+
+import (
+	"context"
+	"time"
+
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	collectInterval = 10 * time.Minute
+)
+
+// Collector deletes the secret copies whose source secret or project no longer exists,
+// because they were removed while the agent wasn't running
+type Collector struct {
+	secrets        v1.SecretInterface
+	clusterSecrets v1.SecretLister
+	getSource      func(kind, namespace, name string) (*corev1.Secret, error)
+	projectLister  v3.ProjectLister
+	clusterName    string
+	events         record.EventRecorder
+	// synced reports whether the caches of the listers the sources are read from are synced,
+	// a source missing from a cache that isn't synced would make every copy of it orphaned
+	synced []cache.InformerSynced
+}
+
+func (c *Collector) collect(ctx context.Context, interval time.Duration) {
+	for range utils.TickerContext(ctx, interval) {
+		if err := c.collectOrphans(); err != nil {
+			logrus.Infof("Failed to collect orphaned secrets: %v", err)
+		}
+	}
+}
+
+func (c *Collector) collectOrphans() error {
+	for _, synced := range c.synced {
+		if !synced() {
+			logrus.Infof("Not collecting orphaned secrets, the caches aren't synced yet")
+			return nil
+		}
+	}
+
+	secrets, err := c.clusterSecrets.List("", labels.NewSelector())
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		if !isCopy(secret) {
+			continue
+		}
+		orphaned, err := c.isOrphaned(secret)
+		if err != nil {
+			return err
+		}
+		if !orphaned {
+			continue
+		}
+
+		logrus.Infof("Deleting orphaned secret [%s] in namespace [%s]", secret.Name, secret.Namespace)
+		if err := c.secrets.DeleteNamespaced(secret.Namespace, secret.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.events.Eventf(secret, corev1.EventTypeNormal, reasonRemoved, "Removed copy of secret [%s] of [%s], the source no longer exists",
+			secret.Name, secret.Labels[sourceNamespaceLabel])
+	}
+	return nil
+}
+
+func (c *Collector) isOrphaned(secret *corev1.Secret) (bool, error) {
+	// namespaced credentials aren't tied to a project
+	if project := secret.Labels[sourceProjectLabel]; project != "" {
+		_, err := c.projectLister.Get(c.clusterName, project)
+		if errors.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	sourceNamespace := secret.Labels[sourceNamespaceLabel]
+	if sourceNamespace == "" {
+		sourceNamespace = secret.Labels[sourceProjectLabel]
+	}
+	// a source recreated under the same name gets copied over the old copy, the copy isn't orphaned
+	source, err := c.getSource(secret.Labels[sourceKindLabel], sourceNamespace, secret.Name)
+	if err != nil {
+		return false, err
+	}
+	if source != nil && secret.Labels[sourceClusterLabel] != "" {
+		// a cluster secret that no longer opts in to being cluster-wide has no copies
+		return !isClusterWide(source, c.clusterName), nil
+	}
+	return source == nil, nil
+}
@@ -0,0 +1,62 @@
+package secret
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	tests := []struct {
+		name        string
+		synced      []bool
+		wantDeleted []string
+	}{
+		{name: "synced", synced: []bool{true, true}, wantDeleted: []string{"ns-1/orphaned", "ns-1/opted-out"}},
+		{name: "not synced", synced: []bool{true, false}},
+	}
+
+	for _, test := range tests {
+		client := &fakeSecretsClient{}
+		c := &Collector{
+			secrets: client,
+			clusterSecrets: &fakeSecrets{secrets: []*corev1.Secret{
+				newSecret("ns-1", "orphaned", map[string]string{sourceClusterLabel: "c-1", sourceNamespaceLabel: "c-1"}),
+				newSecret("ns-1", "current", map[string]string{sourceClusterLabel: "c-1", sourceNamespaceLabel: "c-1"}),
+				newSecret("ns-1", "opted-out", map[string]string{sourceClusterLabel: "c-1", sourceNamespaceLabel: "c-1"}),
+				newSecret("ns-1", "user", nil),
+			}},
+			getSource: func(kind, namespace, name string) (*corev1.Secret, error) {
+				switch name {
+				case "current":
+					return newSecret(namespace, name, map[string]string{clusterWideLabel: "true"}), nil
+				case "opted-out":
+					return newSecret(namespace, name, nil), nil
+				}
+				return nil, nil
+			},
+			clusterName: "c-1",
+			events:      record.NewFakeRecorder(10),
+		}
+		for _, synced := range test.synced {
+			synced := synced
+			c.synced = append(c.synced, cache.InformerSynced(func() bool { return synced }))
+		}
+
+		if err := c.collectOrphans(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(client.deleted) != len(test.wantDeleted) {
+			t.Errorf("%s: got deleted %v, want %v", test.name, client.deleted, test.wantDeleted)
+			continue
+		}
+		for i := range test.wantDeleted {
+			if client.deleted[i] != test.wantDeleted[i] {
+				t.Errorf("%s: got deleted %v, want %v", test.name, client.deleted, test.wantDeleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	projectv3 "github.com/rancher/types/apis/project.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// sourceKindLabel records the kind of typed credential a secret was materialized from
+	sourceKindLabel = "secret.cluster.cattle.io/source-kind"
+
+	dockerCredentialKind           = "DockerCredential"
+	certificateKind                = "Certificate"
+	basicAuthKind                  = "BasicAuth"
+	sshAuthKind                    = "SSHAuth"
+	namespacedKindPrefix           = "Namespaced"
+	namespacedDockerCredentialKind = namespacedKindPrefix + dockerCredentialKind
+	namespacedCertificateKind      = namespacedKindPrefix + certificateKind
+	namespacedBasicAuthKind        = namespacedKindPrefix + basicAuthKind
+	namespacedSSHAuthKind          = namespacedKindPrefix + sshAuthKind
+
+	discoveryRetryInterval    = time.Second
+	discoveryMaxRetryInterval = time.Minute
+)
+
+// CredentialController materializes the typed credentials of projects as native secrets. Project
+// credentials live in the project namespace in management and go to every namespace of the project,
+// Namespaced credentials live in a namespace of the cluster and go to that namespace only
+type CredentialController struct {
+	*Controller
+	dockerCredentials projectv3.DockerCredentialLister
+	certificates      projectv3.CertificateLister
+	basicAuths        projectv3.BasicAuthLister
+	sshAuths          projectv3.SSHAuthLister
+	// synced reports whether the caches of the credential listers are synced
+	synced []cache.InformerSynced
+}
+
+// newCredentialController returns the controller of the credentials of management, and of the cluster
+// unless it is nil because the cluster doesn't serve namespaced credentials
+func newCredentialController(s *Controller, management, cluster projectv3.Interface) *CredentialController {
+	c := &CredentialController{
+		Controller:        s,
+		dockerCredentials: management.DockerCredentials("").Controller().Lister(),
+		certificates:      management.Certificates("").Controller().Lister(),
+		basicAuths:        management.BasicAuths("").Controller().Lister(),
+		sshAuths:          management.SSHAuths("").Controller().Lister(),
+	}
+	c.synced = []cache.InformerSynced{
+		management.DockerCredentials("").Controller().Informer().HasSynced,
+		management.Certificates("").Controller().Informer().HasSynced,
+		management.BasicAuths("").Controller().Informer().HasSynced,
+		management.SSHAuths("").Controller().Informer().HasSynced,
+	}
+
+	sources := map[string]func(namespace, name string) (*corev1.Secret, error){
+		dockerCredentialKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := c.dockerCredentials.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return dockerCredentialSecret(dockerCredentialKind, obj)
+		},
+		certificateKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := c.certificates.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return certificateSecret(certificateKind, obj), nil
+		},
+		basicAuthKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := c.basicAuths.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return basicAuthSecret(basicAuthKind, obj), nil
+		},
+		sshAuthKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := c.sshAuths.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return sshAuthSecret(sshAuthKind, obj), nil
+		},
+	}
+	for kind, get := range sources {
+		s.sources[kind] = get
+	}
+	for kind, get := range c.namespacedSources(cluster) {
+		s.sources[kind] = get
+	}
+	return c
+}
+
+// namespacedSources returns the sources of the namespaced credentials of the cluster. Without
+// cluster there are no namespaced credentials, they are never found
+func (c *CredentialController) namespacedSources(cluster projectv3.Interface) map[string]func(namespace, name string) (*corev1.Secret, error) {
+	if cluster == nil {
+		notFound := func(resource metav1.APIResource) func(namespace, name string) (*corev1.Secret, error) {
+			return func(namespace, name string) (*corev1.Secret, error) {
+				return nil, apierrors.NewNotFound(projectv3.SchemeGroupVersion.WithResource(resource.Name).GroupResource(), name)
+			}
+		}
+		return map[string]func(namespace, name string) (*corev1.Secret, error){
+			namespacedDockerCredentialKind: notFound(projectv3.NamespacedDockerCredentialResource),
+			namespacedCertificateKind:      notFound(projectv3.NamespacedCertificateResource),
+			namespacedBasicAuthKind:        notFound(projectv3.NamespacedBasicAuthResource),
+			namespacedSSHAuthKind:          notFound(projectv3.NamespacedSSHAuthResource),
+		}
+	}
+
+	namespacedDockerCredentials := cluster.NamespacedDockerCredentials("").Controller().Lister()
+	namespacedCertificates := cluster.NamespacedCertificates("").Controller().Lister()
+	namespacedBasicAuths := cluster.NamespacedBasicAuths("").Controller().Lister()
+	namespacedSSHAuths := cluster.NamespacedSSHAuths("").Controller().Lister()
+	c.synced = append(c.synced,
+		cluster.NamespacedDockerCredentials("").Controller().Informer().HasSynced,
+		cluster.NamespacedCertificates("").Controller().Informer().HasSynced,
+		cluster.NamespacedBasicAuths("").Controller().Informer().HasSynced,
+		cluster.NamespacedSSHAuths("").Controller().Informer().HasSynced,
+	)
+	return map[string]func(namespace, name string) (*corev1.Secret, error){
+		namespacedDockerCredentialKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := namespacedDockerCredentials.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return dockerCredentialSecret(namespacedDockerCredentialKind, (*projectv3.DockerCredential)(obj))
+		},
+		namespacedCertificateKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := namespacedCertificates.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return certificateSecret(namespacedCertificateKind, (*projectv3.Certificate)(obj)), nil
+		},
+		namespacedBasicAuthKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := namespacedBasicAuths.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return basicAuthSecret(namespacedBasicAuthKind, (*projectv3.BasicAuth)(obj)), nil
+		},
+		namespacedSSHAuthKind: func(namespace, name string) (*corev1.Secret, error) {
+			obj, err := namespacedSSHAuths.Get(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return sshAuthSecret(namespacedSSHAuthKind, (*projectv3.SSHAuth)(obj)), nil
+		},
+	}
+}
+
+// servesNamespacedCredentials returns whether the cluster serves every namespaced credential resource.
+// The controllers of a resource that isn't served never sync, and would hold the cluster controllers
+func servesNamespacedCredentials(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(projectv3.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to discover the namespaced credential resources")
+	}
+	served := map[string]bool{}
+	for _, resource := range resources.APIResources {
+		served[resource.Name] = true
+	}
+	for _, resource := range []metav1.APIResource{
+		projectv3.NamespacedDockerCredentialResource,
+		projectv3.NamespacedCertificateResource,
+		projectv3.NamespacedBasicAuthResource,
+		projectv3.NamespacedSSHAuthResource,
+	} {
+		if !served[resource.Name] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// waitForNamespacedCredentials returns whether the cluster serves the namespaced credentials, retrying
+// failed discoveries with a doubling interval until one succeeds or the context is done
+func waitForNamespacedCredentials(ctx context.Context, client discovery.DiscoveryInterface, retryInterval, maxRetryInterval time.Duration) (bool, error) {
+	for {
+		served, err := servesNamespacedCredentials(client)
+		if err == nil {
+			return served, nil
+		}
+		logrus.Warnf("Retrying in %v: %v", retryInterval, err)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+		if retryInterval *= 2; retryInterval > maxRetryInterval {
+			retryInterval = maxRetryInterval
+		}
+	}
+}
+
+func (c *CredentialController) register(clusterName string, management, cluster projectv3.Interface) {
+	management.DockerCredentials("").AddClusterScopedHandler("credentialsController", clusterName, func(key string, obj *projectv3.DockerCredential) error {
+		return c.sync(dockerCredentialKind, key, obj == nil)
+	})
+	management.Certificates("").AddClusterScopedHandler("credentialsController", clusterName, func(key string, obj *projectv3.Certificate) error {
+		return c.sync(certificateKind, key, obj == nil)
+	})
+	management.BasicAuths("").AddClusterScopedHandler("credentialsController", clusterName, func(key string, obj *projectv3.BasicAuth) error {
+		return c.sync(basicAuthKind, key, obj == nil)
+	})
+	management.SSHAuths("").AddClusterScopedHandler("credentialsController", clusterName, func(key string, obj *projectv3.SSHAuth) error {
+		return c.sync(sshAuthKind, key, obj == nil)
+	})
+	if cluster == nil {
+		return
+	}
+	cluster.NamespacedDockerCredentials("").AddHandler("credentialsController", func(key string, obj *projectv3.NamespacedDockerCredential) error {
+		return c.sync(namespacedDockerCredentialKind, key, obj == nil)
+	})
+	cluster.NamespacedCertificates("").AddHandler("credentialsController", func(key string, obj *projectv3.NamespacedCertificate) error {
+		return c.sync(namespacedCertificateKind, key, obj == nil)
+	})
+	cluster.NamespacedBasicAuths("").AddHandler("credentialsController", func(key string, obj *projectv3.NamespacedBasicAuth) error {
+		return c.sync(namespacedBasicAuthKind, key, obj == nil)
+	})
+	cluster.NamespacedSSHAuths("").AddHandler("credentialsController", func(key string, obj *projectv3.NamespacedSSHAuth) error {
+		return c.sync(namespacedSSHAuthKind, key, obj == nil)
+	})
+}
+
+func (c *CredentialController) sync(kind, key string, deleted bool) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return c.removeCredential(kind, namespace, name)
+	}
+
+	secret, err := c.getSource(kind, namespace, name)
+	if err != nil || secret == nil {
+		return err
+	}
+	return c.createOrUpdate(secret)
+}
+
+// listCredentials returns the secrets the credentials of a project are materialized as
+func (c *CredentialController) listCredentials(projectName string) ([]*corev1.Secret, error) {
+	var secrets []*corev1.Secret
+	dockerCredentials, err := c.dockerCredentials.List(projectName, labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range dockerCredentials {
+		secret, err := dockerCredentialSecret(dockerCredentialKind, obj)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	certificates, err := c.certificates.List(projectName, labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range certificates {
+		secrets = append(secrets, certificateSecret(certificateKind, obj))
+	}
+	basicAuths, err := c.basicAuths.List(projectName, labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range basicAuths {
+		secrets = append(secrets, basicAuthSecret(basicAuthKind, obj))
+	}
+	sshAuths, err := c.sshAuths.List(projectName, labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range sshAuths {
+		secrets = append(secrets, sshAuthSecret(sshAuthKind, obj))
+	}
+	return secrets, nil
+}
+
+// removeCredential deletes the secrets materialized from a deleted credential
+func (c *CredentialController) removeCredential(kind, namespace, name string) error {
+	kindRequirement, err := labels.NewRequirement(sourceKindLabel, selection.Equals, []string{kind})
+	if err != nil {
+		return err
+	}
+	namespaceRequirement, err := labels.NewRequirement(sourceNamespaceLabel, selection.Equals, []string{namespace})
+	if err != nil {
+		return err
+	}
+	secrets, err := c.clusterSecrets.List("", labels.NewSelector().Add(*kindRequirement, *namespaceRequirement))
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if secret.Name != name {
+			continue
+		}
+		if err := c.deleteCopy(secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNamespacedKind(kind string) bool {
+	return strings.HasPrefix(kind, namespacedKindPrefix)
+}
+
+func credentialSecret(kind string, source *corev1.Secret) *corev1.Secret {
+	if source.Labels == nil {
+		source.Labels = map[string]string{}
+	}
+	source.Labels[sourceKindLabel] = kind
+	return source
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// dockerCredentialSecret converts a DockerCredential with all of its registries to a dockerconfigjson secret
+func dockerCredentialSecret(kind string, obj *projectv3.DockerCredential) (*corev1.Secret, error) {
+	config := dockerConfigJSON{
+		Auths: map[string]dockerConfigEntry{},
+	}
+	for registry, credential := range obj.Registries {
+		auth := credential.Auth
+		if auth == "" {
+			auth = base64.StdEncoding.EncodeToString([]byte(credential.Username + ":" + credential.Password))
+		}
+		config.Auths[registry] = dockerConfigEntry{
+			Username: credential.Username,
+			Password: credential.Password,
+			Auth:     auth,
+		}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode docker credential [%s]", obj.Name)
+	}
+
+	return credentialSecret(kind, &corev1.Secret{
+		ObjectMeta: *obj.ObjectMeta.DeepCopy(),
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: data,
+		},
+	}), nil
+}
+
+// certificateSecret converts a Certificate to a tls secret
+func certificateSecret(kind string, obj *projectv3.Certificate) *corev1.Secret {
+	return credentialSecret(kind, &corev1.Secret{
+		ObjectMeta: *obj.ObjectMeta.DeepCopy(),
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(obj.Certs),
+			corev1.TLSPrivateKeyKey: []byte(obj.Key),
+		},
+	})
+}
+
+// basicAuthSecret converts a BasicAuth to a basic-auth secret
+func basicAuthSecret(kind string, obj *projectv3.BasicAuth) *corev1.Secret {
+	return credentialSecret(kind, &corev1.Secret{
+		ObjectMeta: *obj.ObjectMeta.DeepCopy(),
+		Type:       corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte(obj.Username),
+			corev1.BasicAuthPasswordKey: []byte(obj.Password),
+		},
+	})
+}
+
+// sshAuthSecret converts an SSHAuth to an ssh-auth secret
+func sshAuthSecret(kind string, obj *projectv3.SSHAuth) *corev1.Secret {
+	return credentialSecret(kind, &corev1.Secret{
+		ObjectMeta: *obj.ObjectMeta.DeepCopy(),
+		Type:       corev1.SecretTypeSSHAuth,
+		Data: map[string][]byte{
+			corev1.SSHAuthPrivateKey: []byte(obj.PrivateKey),
+		},
+	})
+}
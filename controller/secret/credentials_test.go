@@ -0,0 +1,203 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	projectv3 "github.com/rancher/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func credentialMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace: "p-1",
+		Name:      "creds",
+		Labels:    map[string]string{"team": "a"},
+	}
+}
+
+func TestCredentialSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		convert  func(kind string) (*corev1.Secret, error)
+		wantType corev1.SecretType
+		wantData map[string]string
+	}{
+		{
+			name: "docker credential",
+			kind: dockerCredentialKind,
+			convert: func(kind string) (*corev1.Secret, error) {
+				return dockerCredentialSecret(kind, &projectv3.DockerCredential{
+					ObjectMeta: credentialMeta(),
+					Registries: map[string]projectv3.RegistryCredential{
+						"index.docker.io": {Username: "user", Password: "pass"},
+						"quay.io":         {Username: "robot", Auth: "cm9ib3Q6dG9rZW4="},
+					},
+				})
+			},
+			wantType: corev1.SecretTypeDockerConfigJson,
+			wantData: map[string]string{
+				corev1.DockerConfigJsonKey: `{"auths":{"index.docker.io":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"},"quay.io":{"username":"robot","auth":"cm9ib3Q6dG9rZW4="}}}`,
+			},
+		},
+		{
+			name: "docker credential without registries",
+			kind: namespacedDockerCredentialKind,
+			convert: func(kind string) (*corev1.Secret, error) {
+				return dockerCredentialSecret(kind, &projectv3.DockerCredential{ObjectMeta: credentialMeta()})
+			},
+			wantType: corev1.SecretTypeDockerConfigJson,
+			wantData: map[string]string{corev1.DockerConfigJsonKey: `{"auths":{}}`},
+		},
+		{
+			name: "certificate",
+			kind: certificateKind,
+			convert: func(kind string) (*corev1.Secret, error) {
+				return certificateSecret(kind, &projectv3.Certificate{ObjectMeta: credentialMeta(), Certs: "cert", Key: "key"}), nil
+			},
+			wantType: corev1.SecretTypeTLS,
+			wantData: map[string]string{corev1.TLSCertKey: "cert", corev1.TLSPrivateKeyKey: "key"},
+		},
+		{
+			name: "basic auth",
+			kind: basicAuthKind,
+			convert: func(kind string) (*corev1.Secret, error) {
+				return basicAuthSecret(kind, &projectv3.BasicAuth{ObjectMeta: credentialMeta(), Username: "user", Password: "pass"}), nil
+			},
+			wantType: corev1.SecretTypeBasicAuth,
+			wantData: map[string]string{corev1.BasicAuthUsernameKey: "user", corev1.BasicAuthPasswordKey: "pass"},
+		},
+		{
+			name: "ssh auth",
+			kind: namespacedSSHAuthKind,
+			convert: func(kind string) (*corev1.Secret, error) {
+				return sshAuthSecret(kind, &projectv3.SSHAuth{ObjectMeta: credentialMeta(), PrivateKey: "private key", Fingerprint: "fingerprint"}), nil
+			},
+			wantType: corev1.SecretTypeSSHAuth,
+			wantData: map[string]string{corev1.SSHAuthPrivateKey: "private key"},
+		},
+	}
+
+	for _, test := range tests {
+		secret, err := test.convert(test.kind)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if secret.Namespace != "p-1" || secret.Name != "creds" {
+			t.Errorf("%s: got secret [%s] in namespace [%s]", test.name, secret.Name, secret.Namespace)
+		}
+		wantLabels := map[string]string{"team": "a", sourceKindLabel: test.kind}
+		if !reflect.DeepEqual(secret.Labels, wantLabels) {
+			t.Errorf("%s: got labels %v, want %v", test.name, secret.Labels, wantLabels)
+		}
+		if secret.Type != test.wantType {
+			t.Errorf("%s: got type %s, want %s", test.name, secret.Type, test.wantType)
+		}
+		data := map[string]string{}
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+		if !reflect.DeepEqual(data, test.wantData) {
+			t.Errorf("%s: got data %v, want %v", test.name, data, test.wantData)
+		}
+	}
+}
+
+func TestCredentialSecretKeepsSource(t *testing.T) {
+	obj := &projectv3.BasicAuth{ObjectMeta: credentialMeta()}
+	basicAuthSecret(basicAuthKind, obj)
+	if _, ok := obj.Labels[sourceKindLabel]; ok {
+		t.Error("the labels of the credential were modified")
+	}
+}
+
+func TestServesNamespacedCredentials(t *testing.T) {
+	allResources := []string{"namespaceddockercredentials", "namespacedcertificates", "namespacedbasicauths", "namespacedsshauths", "workloads"}
+	tests := []struct {
+		name      string
+		status    int
+		resources []string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "served", status: http.StatusOK, resources: allResources, want: true},
+		{name: "partially served", status: http.StatusOK, resources: allResources[1:]},
+		{name: "group not served", status: http.StatusNotFound},
+		{name: "discovery failure", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			if req.URL.Path != "/apis/project.cattle.io/v3" || test.status != http.StatusOK {
+				rw.WriteHeader(test.status)
+				json.NewEncoder(rw).Encode(&metav1.Status{Status: metav1.StatusFailure, Code: int32(test.status)})
+				return
+			}
+			list := &metav1.APIResourceList{GroupVersion: "project.cattle.io/v3"}
+			for _, name := range test.resources {
+				list.APIResources = append(list.APIResources, metav1.APIResource{Name: name, Namespaced: true})
+			}
+			json.NewEncoder(rw).Encode(list)
+		}))
+		client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		served, err := servesNamespacedCredentials(client.Discovery())
+		server.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		if served != test.want {
+			t.Errorf("%s: got served %v, want %v", test.name, served, test.want)
+		}
+	}
+}
+
+func TestWaitForNamespacedCredentials(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		// discovery fails until the third request, which reports the group as not served
+		status := http.StatusNotFound
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			status = http.StatusInternalServerError
+		}
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(&metav1.Status{Status: metav1.StatusFailure, Code: int32(status)})
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served, err := waitForNamespacedCredentials(context.Background(), client.Discovery(), time.Millisecond, 2*time.Millisecond)
+	if err != nil || served {
+		t.Errorf("got served %v, error %v, want not served", served, err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("got %d discoveries, want 3", got)
+	}
+
+	// a cancelled context stops the retries of a discovery that keeps failing
+	atomic.StoreInt32(&requests, -100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := waitForNamespacedCredentials(ctx, client.Discovery(), time.Hour, time.Hour); err == nil {
+		t.Error("got no error for a cancelled context")
+	}
+}
@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// contentHashAnnotation records the hash of the source content a copy was written with
+	contentHashAnnotation = "secret.cluster.cattle.io/content-hash"
+
+	reasonRestored = "SecretRestored"
+)
+
+// contentHash hashes the type and data of a secret
+func contentHash(secret *corev1.Secret) string {
+	hash := sha256.New()
+	hash.Write([]byte(secret.Type))
+	hash.Write([]byte{0})
+	for _, data := range []map[string][]byte{secret.Data, stringData(secret)} {
+		projectcopy.HashData(hash, data)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func stringData(secret *corev1.Secret) map[string][]byte {
+	data := map[string][]byte{}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+	return data
+}
+
+// isUpToDate returns whether the copy holds the current content of the source, and wasn't edited since
+func isUpToDate(namespacedSecret *corev1.Secret, source *corev1.Secret) bool {
+	hash := namespacedSecret.Annotations[contentHashAnnotation]
+	return hash != "" && hash == contentHash(source) && hash == contentHash(namespacedSecret)
+}
+
+// syncCopy restores the copies of project secrets that were edited or deleted in the cluster
+func (s *Controller) syncCopy(key string, obj *corev1.Secret) error {
+	if obj != nil && (!isCopy(obj) || obj.DeletionTimestamp != nil) {
+		return nil
+	}
+	namespaceName, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.getSourceSecret(namespaceName, name, obj)
+	if err != nil || source == nil {
+		return err
+	}
+	if obj != nil && isUpToDate(obj, source) {
+		return nil
+	}
+
+	clusterNamespaces, err := s.getTargetNamespaces(source)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range clusterNamespaces {
+		if namespace.Name != namespaceName || namespace.DeletionTimestamp != nil {
+			continue
+		}
+		restored, err := s.ensureCopy(source, namespace)
+		if err != nil {
+			return err
+		}
+		if restored == nil {
+			return nil
+		}
+		if obj == nil {
+			s.events.Eventf(restored, corev1.EventTypeNormal, reasonRestored, "Restored deleted copy of secret [%s] of [%s]", source.Name, source.Namespace)
+		} else {
+			s.events.Eventf(restored, corev1.EventTypeNormal, reasonRestored, "Restored modified copy of secret [%s] of [%s]", source.Name, source.Namespace)
+		}
+	}
+	return nil
+}
+
+// getSourceSecret returns the secret or credential a copy is made from. For a deleted copy, it is the
+// secret or project credential of the same name in the project of the namespace, the namespaced
+// credential of the same name in the namespace, or the cluster-wide secret of the same name selecting
+// the namespace. Nil is returned if there is none
+func (s *Controller) getSourceSecret(namespaceName, name string, namespacedSecret *corev1.Secret) (*corev1.Secret, error) {
+	if namespacedSecret != nil {
+		sourceNamespace := namespacedSecret.Labels[sourceNamespaceLabel]
+		if sourceNamespace == "" {
+			sourceNamespace = namespacedSecret.Labels[sourceProjectLabel]
+		}
+		return s.getSource(namespacedSecret.Labels[sourceKindLabel], sourceNamespace, name)
+	}
+
+	namespace, err := s.clusterNamespaceLister.Get("", namespaceName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// field.cattle.io/projectId value is <cluster name>:<project name>
+	parts := strings.Split(namespace.Annotations[projectIDLabel], ":")
+	if len(parts) == 2 && parts[0] == s.clusterName {
+		for _, kind := range []string{"", dockerCredentialKind, certificateKind, basicAuthKind, sshAuthKind} {
+			source, err := s.getSource(kind, parts[1], name)
+			if err != nil || source != nil {
+				return source, err
+			}
+		}
+	}
+	for _, kind := range []string{namespacedDockerCredentialKind, namespacedCertificateKind, namespacedBasicAuthKind, namespacedSSHAuthKind} {
+		source, err := s.getSource(kind, namespaceName, name)
+		if err != nil || source != nil {
+			return source, err
+		}
+	}
+
+	// cluster-wide secrets are in the management namespace named after the cluster
+	source, err := s.getSource("", s.clusterName, name)
+	if err != nil || source == nil || !isClusterWide(source, s.clusterName) {
+		return nil, err
+	}
+	if matches, err := matchesClusterWide(source, namespace); err != nil || !matches {
+		return nil, err
+	}
+	return source, nil
+}
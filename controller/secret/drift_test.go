@@ -0,0 +1,151 @@
+package secret
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1beta2 "github.com/rancher/types/apis/apps/v1beta2"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	k8sappsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeNamespaces struct {
+	v1.NamespaceLister
+	namespaces []*corev1.Namespace
+}
+
+func (f *fakeNamespaces) Get(namespace, name string) (*corev1.Namespace, error) {
+	for _, ns := range f.namespaces {
+		if ns.Name == name {
+			return ns, nil
+		}
+	}
+	return nil, errors.NewNotFound(corev1.Resource("namespaces"), name)
+}
+
+func (f *fakeNamespaces) List(namespace string, selector labels.Selector) ([]*corev1.Namespace, error) {
+	return f.namespaces, nil
+}
+
+type fakeProjects struct {
+	v3.ProjectLister
+}
+
+func (f *fakeProjects) Get(namespace, name string) (*v3.Project, error) {
+	return &v3.Project{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+}
+
+func newNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+type fakeDeployments struct {
+	appsv1beta2.DeploymentLister
+	deployments []*k8sappsv1beta2.Deployment
+}
+
+func (f *fakeDeployments) List(namespace string, selector labels.Selector) ([]*k8sappsv1beta2.Deployment, error) {
+	var result []*k8sappsv1beta2.Deployment
+	for _, deployment := range f.deployments {
+		if deployment.Namespace == namespace {
+			result = append(result, deployment)
+		}
+	}
+	return result, nil
+}
+
+func newWorkloadIndexer(workloads ...interface{}) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, workload := range workloads {
+		indexer.Add(workload)
+	}
+	return indexer
+}
+
+// newTestController returns a controller copying the secrets of project p-1 of cluster c-1 to
+// namespace ns-1, with the cluster secrets given
+func newTestController(source *corev1.Secret, clusterSecrets ...*corev1.Secret) (*Controller, *fakeSecretsClient, *record.FakeRecorder) {
+	managementSecrets := &fakeSecrets{secrets: []*corev1.Secret{source}}
+	client := &fakeSecretsClient{}
+	events := record.NewFakeRecorder(10)
+	s := &Controller{
+		secrets:                client,
+		clusterSecrets:         &fakeSecrets{secrets: clusterSecrets},
+		managementSecrets:      managementSecrets,
+		clusterNamespaceLister: &fakeNamespaces{namespaces: []*corev1.Namespace{newNamespace("ns-1", map[string]string{projectIDLabel: "c-1:p-1"})}},
+		managementNamespaceLister: &fakeNamespaces{namespaces: []*corev1.Namespace{
+			newNamespace("p-1", map[string]string{projectNamespaceAnnotation: "true"}),
+			newNamespace("c-1", nil),
+		}},
+		projectLister: &fakeProjects{},
+		clusterName:   "c-1",
+		events:        events,
+		sources: map[string]func(namespace, name string) (*corev1.Secret, error){
+			"": managementSecrets.Get,
+		},
+		rollout: &Rollout{
+			secrets:        client,
+			clusterSecrets: &fakeSecrets{secrets: clusterSecrets},
+			deployments:    &fakeDeployments{},
+			statefulSets:   newWorkloadIndexer(),
+			daemonSets:     newWorkloadIndexer(),
+			events:         events,
+		},
+	}
+	return s, client, events
+}
+
+func TestSyncCopy(t *testing.T) {
+	source := newSecret("p-1", "creds", nil)
+	upToDate := copySecret(source, "ns-1", "c-1")
+	modified := copySecret(source, "ns-1", "c-1")
+	modified.Data = map[string][]byte{"key": []byte("edited")}
+	notCopy := newSecret("ns-1", "creds", nil)
+	notCopy.Data = map[string][]byte{"key": []byte("edited")}
+
+	tests := []struct {
+		name        string
+		existing    *corev1.Secret
+		wantCreated bool
+		wantUpdated bool
+	}{
+		{name: "modified copy", existing: modified, wantUpdated: true},
+		{name: "deleted copy", wantCreated: true},
+		{name: "up to date copy", existing: upToDate},
+		{name: "not a copy", existing: notCopy},
+	}
+
+	for _, test := range tests {
+		var clusterSecrets []*corev1.Secret
+		if test.existing != nil {
+			clusterSecrets = append(clusterSecrets, test.existing)
+		}
+		s, client, events := newTestController(source, clusterSecrets...)
+
+		if err := s.syncCopy("ns-1/creds", test.existing); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if created := len(client.created) == 1; created != test.wantCreated {
+			t.Errorf("%s: got created %v, want %v", test.name, client.created, test.wantCreated)
+		}
+		if updated := len(client.updated) == 1; updated != test.wantUpdated {
+			t.Errorf("%s: got updated %v, want %v", test.name, client.updated, test.wantUpdated)
+		}
+		for _, restored := range append(client.created, client.updated...) {
+			if !reflect.DeepEqual(restored.Data, source.Data) || !isUpToDate(restored, source) {
+				t.Errorf("%s: got restored data %q, want %q", test.name, restored.Data, source.Data)
+			}
+			if len(events.Events) == 0 {
+				t.Errorf("%s: no event for the restored copy", test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package secret
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// publicKeyAnnotation publishes on the Cluster the PEM encoded public key secrets are encrypted to
+	publicKeyAnnotation = "secret.cluster.cattle.io/public-key"
+	// encryptedKeyAnnotation marks an encrypted source secret. It holds the base64 encoded data key,
+	// encrypted to the public key of the cluster with RSA-OAEP and SHA-256. Each data value is the
+	// AES-GCM nonce followed by the value sealed with the data key, the data key name being the
+	// additional data
+	encryptedKeyAnnotation = "secret.cluster.cattle.io/encrypted-key"
+	privateKeyDataKey      = "key.pem"
+	privateKeyBits         = 4096
+	publishInterval        = 10 * time.Minute
+	keyRetryInterval       = 30 * time.Second
+	// publishRetries bounds the patches of the Cluster retried on conflicts
+	publishRetries = 5
+
+	reasonDecryptionFailed = "SecretDecryptionFailed"
+)
+
+// Options holds the tunables of the secret controllers
+type Options struct {
+	// KeySecret is the <namespace>/<name> of the cluster secret holding the private key of the agent,
+	// empty to disable encrypted secrets
+	KeySecret string
+}
+
+// Encryption decrypts the source secrets encrypted to the key of the agent. The private key never
+// leaves the cluster, only the public key is published on the Cluster
+type Encryption struct {
+	secrets      v1.SecretInterface
+	keyNamespace string
+	keyName      string
+	clusters     v3.ClusterInterface
+	// management patches the annotations of the Cluster, the cluster client can't patch custom resources
+	management  rest.Interface
+	clusterName string
+	// events records the values that fail to decrypt on the source secrets in management
+	events record.EventRecorder
+
+	lock sync.RWMutex
+	key  *rsa.PrivateKey
+}
+
+// newEncryption returns the encryption with the private key of the agent in the cluster secret, the key
+// is loaded by run. It returns nil when encrypted secrets are disabled
+func newEncryption(keySecret string, secrets v1.SecretInterface, clusters v3.ClusterInterface, management rest.Interface, clusterName string, events record.EventRecorder) (*Encryption, error) {
+	if keySecret == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(keySecret, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid key secret [%s], expected <namespace>/<name>", keySecret)
+	}
+	return &Encryption{
+		secrets:      secrets,
+		keyNamespace: parts[0],
+		keyName:      parts[1],
+		clusters:     clusters,
+		management:   management,
+		clusterName:  clusterName,
+		events:       events,
+	}, nil
+}
+
+// run loads the private key, generating it the first time and retrying until it succeeds, then keeps
+// the public key published on the Cluster. Encrypted secrets fail to decrypt until the key is loaded
+func (e *Encryption) run(ctx context.Context, retryInterval, publishInterval time.Duration) {
+	for {
+		key, err := loadOrCreateKey(e.secrets, e.keyNamespace, e.keyName)
+		if err == nil {
+			e.lock.Lock()
+			e.key = key
+			e.lock.Unlock()
+			break
+		}
+		logrus.Errorf("Failed to load the key for encrypted secrets from secret [%s] in namespace [%s]: %v", e.keyName, e.keyNamespace, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+	e.publish(ctx, e.getKey(), publishInterval)
+}
+
+func (e *Encryption) getKey() *rsa.PrivateKey {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.key
+}
+
+func loadOrCreateKey(secrets v1.SecretInterface, namespace, name string) (*rsa.PrivateKey, error) {
+	secret, err := secrets.GetNamespaced(namespace, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("Generating the key for encrypted secrets into secret [%s] in namespace [%s]", name, namespace)
+		key, err := rsa.GenerateKey(rand.Reader, privateKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string][]byte{
+				privateKeyDataKey: pem.EncodeToMemory(&pem.Block{
+					Type:  "RSA PRIVATE KEY",
+					Bytes: x509.MarshalPKCS1PrivateKey(key),
+				}),
+			},
+		}
+		if _, err = secrets.Create(secret); err == nil {
+			return key, nil
+		} else if !apierrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+		// another agent generated it first
+		secret, err = secrets.GetNamespaced(namespace, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(secret.Data[privateKeyDataKey])
+	if block == nil {
+		return nil, errors.Errorf("no PEM encoded key in [%s]", privateKeyDataKey)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// publish keeps the public key of the private key published on the Cluster
+func (e *Encryption) publish(ctx context.Context, key *rsa.PrivateKey, interval time.Duration) {
+	if err := e.publishKey(key); err != nil {
+		logrus.Errorf("Failed to publish the public key for encrypted secrets: %v", err)
+	}
+	for range utils.TickerContext(ctx, interval) {
+		if err := e.publishKey(key); err != nil {
+			logrus.Errorf("Failed to publish the public key for encrypted secrets: %v", err)
+		}
+	}
+}
+
+func (e *Encryption) publishKey(key *rsa.PrivateKey) error {
+	if key == nil {
+		return errors.New("the key for encrypted secrets isn't loaded")
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}))
+
+	cluster, err := e.clusters.Get(e.clusterName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations[publicKeyAnnotation] == publicKey {
+		return nil
+	}
+
+	// only the annotation is patched, the agent doesn't own the rest of the Cluster
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{publicKeyAnnotation: publicKey},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Publishing the public key for encrypted secrets on cluster [%s]", e.clusterName)
+	for i := 0; i < publishRetries; i++ {
+		err = e.management.Patch(types.MergePatchType).
+			Prefix("apis", v3.GroupName, v3.Version).
+			Resource(v3.ClusterResource.Name).
+			Name(e.clusterName).
+			Body(patch).
+			Do().
+			Error()
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// decrypt returns the secret with its data decrypted, or the secret itself if it isn't encrypted
+func (e *Encryption) decrypt(obj *corev1.Secret) (*corev1.Secret, error) {
+	encryptedKey, ok := obj.Annotations[encryptedKeyAnnotation]
+	if !ok {
+		return obj, nil
+	}
+	if e == nil {
+		return nil, errors.Errorf("secret [%s] of [%s] is encrypted, but encrypted secrets are disabled", obj.Name, obj.Namespace)
+	}
+	key := e.getKey()
+	if key == nil {
+		return nil, errors.Errorf("secret [%s] of [%s] is encrypted, but the key for encrypted secrets isn't loaded yet", obj.Name, obj.Namespace)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(encryptedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid encrypted key of secret [%s] of [%s]", obj.Name, obj.Namespace)
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, wrapped, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt the key of secret [%s] of [%s]", obj.Name, obj.Namespace)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid key of secret [%s] of [%s]", obj.Name, obj.Namespace)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := obj.DeepCopy()
+	delete(decrypted.Annotations, encryptedKeyAnnotation)
+	for name, value := range obj.Data {
+		if len(value) < gcm.NonceSize() {
+			e.recordDecryptionFailure(obj, name, "value too short")
+			return nil, errors.Errorf("failed to decrypt [%s] of secret [%s] of [%s]: value too short", name, obj.Name, obj.Namespace)
+		}
+		plaintext, err := gcm.Open(nil, value[:gcm.NonceSize()], value[gcm.NonceSize():], []byte(name))
+		if err != nil {
+			e.recordDecryptionFailure(obj, name, err.Error())
+			return nil, errors.Wrapf(err, "failed to decrypt [%s] of secret [%s] of [%s]", name, obj.Name, obj.Namespace)
+		}
+		decrypted.Data[name] = plaintext
+	}
+	return decrypted, nil
+}
+
+// recordDecryptionFailure records on the source secret the value that failed to decrypt, it is fixed by
+// encrypting the value again
+func (e *Encryption) recordDecryptionFailure(obj *corev1.Secret, name, reason string) {
+	e.events.Eventf(obj, corev1.EventTypeWarning, reasonDecryptionFailed, "Failed to decrypt [%s]: %s", name, reason)
+}
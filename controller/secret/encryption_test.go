@@ -0,0 +1,128 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+// encrypt encrypts the data to the public key the way the secrets encrypted to the agent are, each
+// value sealed with the additional data given for its name
+func encrypt(t *testing.T, publicKey *rsa.PublicKey, data map[string][]byte, additionalData map[string]string) (string, map[string][]byte) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := map[string][]byte{}
+	for name, value := range data {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatal(err)
+		}
+		aad := name
+		if override, ok := additionalData[name]; ok {
+			aad = override
+		}
+		encrypted[name] = gcm.Seal(nonce, nonce, value, []byte(aad))
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), encrypted
+}
+
+func TestDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string][]byte{"username": []byte("admin"), "password": []byte("secret")}
+
+	tests := []struct {
+		name           string
+		additionalData map[string]string
+		tamper         bool
+		truncate       bool
+		wantErr        bool
+	}{
+		{name: "round trip"},
+		{name: "wrong additional data", additionalData: map[string]string{"password": "username"}, wantErr: true},
+		{name: "tampered value", tamper: true, wantErr: true},
+		{name: "truncated value", truncate: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		encryptedKey, encrypted := encrypt(t, &key.PublicKey, data, test.additionalData)
+		if test.tamper {
+			encrypted["password"][len(encrypted["password"])-1] ^= 1
+		}
+		if test.truncate {
+			encrypted["password"] = encrypted["password"][:4]
+		}
+		secret := newSecret("p-1", "creds", nil)
+		secret.Annotations = map[string]string{encryptedKeyAnnotation: encryptedKey}
+		secret.Data = encrypted
+
+		events := record.NewFakeRecorder(10)
+		decrypted, err := (&Encryption{key: key, events: events}).decrypt(secret)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", test.name)
+			}
+			// the failure is recorded on the source secret
+			if len(events.Events) != 1 {
+				t.Errorf("%s: got %d events, want 1", test.name, len(events.Events))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decrypted.Data, data) {
+			t.Errorf("%s: got data %q, want %q", test.name, decrypted.Data, data)
+		}
+		if _, ok := decrypted.Annotations[encryptedKeyAnnotation]; ok {
+			t.Errorf("%s: decrypted secret is still marked encrypted", test.name)
+		}
+	}
+}
+
+func TestDecryptWithoutKey(t *testing.T) {
+	secret := newSecret("p-1", "creds", nil)
+	if decrypted, err := (&Encryption{}).decrypt(secret); err != nil || decrypted != secret {
+		t.Errorf("got %v, %v for a secret that isn't encrypted", decrypted, err)
+	}
+
+	secret.Annotations = map[string]string{encryptedKeyAnnotation: "key"}
+	if _, err := (&Encryption{}).decrypt(secret); err == nil {
+		t.Error("got no error before the key is loaded")
+	}
+	var disabled *Encryption
+	if _, err := disabled.decrypt(secret); err == nil {
+		t.Error("got no error with encrypted secrets disabled")
+	}
+}
+
+func TestPublishKeyWithoutKey(t *testing.T) {
+	if err := (&Encryption{}).publishKey(nil); err == nil {
+		t.Error("got no error publishing without key")
+	}
+}
@@ -0,0 +1,33 @@
+package secret
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// sourceNamespaceLabel records the management namespace of the secret a copy was made from
+	sourceNamespaceLabel = "secret.cluster.cattle.io/source-namespace"
+	// sourceUIDLabel records the UID of the secret a copy was made from
+	sourceUIDLabel = "secret.cluster.cattle.io/source-uid"
+)
+
+// isOwnedCopy returns whether the secret is a copy of the source made by the agent, and can be
+// overwritten or deleted. Only the source labels are trusted, a secret without them is never
+// taken over even if it matches the source
+func isOwnedCopy(secret *corev1.Secret, source *corev1.Secret) bool {
+	if kind, ok := secret.Labels[sourceKindLabel]; ok {
+		return kind == source.Labels[sourceKindLabel] && secret.Labels[sourceNamespaceLabel] == source.Namespace
+	}
+	if cluster, ok := secret.Labels[sourceClusterLabel]; ok {
+		return cluster == source.Namespace && source.Labels[sourceKindLabel] == ""
+	}
+	if project, ok := secret.Labels[sourceProjectLabel]; ok {
+		return project == source.Namespace
+	}
+	return false
+}
+
+// isCopy returns whether the secret has been copied by the agent
+func isCopy(secret *corev1.Secret) bool {
+	return secret.Labels[sourceProjectLabel] != "" || secret.Labels[sourceKindLabel] != "" || secret.Labels[sourceClusterLabel] != ""
+}
@@ -0,0 +1,31 @@
+package secret
+
+import (
+	"testing"
+)
+
+func TestIsOwnedCopy(t *testing.T) {
+	source := newSecret("p-1", "secret", nil)
+	source.Annotations = map[string]string{"field.cattle.io/description": "shared"}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "project copy", labels: map[string]string{sourceProjectLabel: "p-1"}, want: true},
+		{name: "copy of another project", labels: map[string]string{sourceProjectLabel: "p-2"}},
+		{name: "cluster wide copy of another source", labels: map[string]string{sourceClusterLabel: "c-1"}},
+		{name: "credential copy of a plain secret", labels: map[string]string{sourceKindLabel: dockerCredentialKind, sourceNamespaceLabel: "p-1"}},
+		{name: "unlabeled secret with the same content"},
+	}
+
+	for _, test := range tests {
+		// same annotations, type and data as the source, only the labels decide
+		secret := newSecret("app", "secret", test.labels)
+		secret.Annotations = source.Annotations
+		if got := isOwnedCopy(secret, source); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
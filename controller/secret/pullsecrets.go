@@ -0,0 +1,275 @@
+package secret
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// imagePullServiceAccountAnnotation opts a project, or a single registry credential, in to having its
+	// copies added to the imagePullSecrets of a service account. The value names the service account,
+	// default if empty. The annotation of a credential takes precedence over the one of its project
+	imagePullServiceAccountAnnotation = "secret.cluster.cattle.io/image-pull-service-account"
+	// attachedPullSecretsAnnotation records on a service account the imagePullSecrets added by the agent,
+	// so references added by users are never removed
+	attachedPullSecretsAnnotation = "secret.cluster.cattle.io/attached-pull-secrets"
+	defaultServiceAccount         = "default"
+
+	reasonPullSecretAttached = "ImagePullSecretAttached"
+	reasonPullSecretDetached = "ImagePullSecretDetached"
+)
+
+// PullSecretsController adds the copies of registry credentials to the imagePullSecrets of the
+// service account their project or credential opted in with, and removes them once the copy is gone
+type PullSecretsController struct {
+	k8sClient kubernetes.Interface
+	// serviceAccounts is the cache of the service accounts of the cluster, indexed by namespace
+	serviceAccounts        cache.Indexer
+	serviceAccountsSynced  cache.InformerSynced
+	clusterSecrets         v1.SecretLister
+	clusterSecretsClient   v1.SecretInterface
+	clusterNamespaceLister v1.NamespaceLister
+	projectLister          v3.ProjectLister
+	clusterName            string
+	events                 record.EventRecorder
+}
+
+func (p *PullSecretsController) sync(key string, obj *corev1.Secret) error {
+	if obj != nil && !isPullSecretCopy(obj) {
+		return nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	var serviceAccount string
+	if obj != nil && obj.DeletionTimestamp == nil {
+		if serviceAccount, err = p.getServiceAccount(obj); err != nil {
+			return err
+		}
+	}
+	return p.attach(namespace, name, serviceAccount)
+}
+
+// syncProject resyncs the registry credential copies in the namespaces of the project when it opts
+// in or out
+func (p *PullSecretsController) syncProject(key string, obj *v3.Project) error {
+	_, projectName, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	namespaces, err := p.clusterNamespaceLister.List("", labels.NewSelector())
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		// field.cattle.io/projectId value is <cluster name>:<project name>
+		if namespace.Annotations[projectIDLabel] != p.clusterName+":"+projectName {
+			continue
+		}
+		secrets, err := p.clusterSecrets.List(namespace.Name, labels.NewSelector())
+		if err != nil {
+			return err
+		}
+		for _, secret := range secrets {
+			if isPullSecretCopy(secret) {
+				p.clusterSecretsClient.Controller().Enqueue(secret.Namespace, secret.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// serviceAccountHandler resyncs the registry credential copies of the namespace of a service account
+// when it is created, or its imagePullSecrets change, so a missing reference is added back
+func (p *PullSecretsController) serviceAccountHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.syncServiceAccount(obj.(*corev1.ServiceAccount))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, serviceAccount := oldObj.(*corev1.ServiceAccount), newObj.(*corev1.ServiceAccount)
+			if !reflect.DeepEqual(old.ImagePullSecrets, serviceAccount.ImagePullSecrets) ||
+				old.Annotations[attachedPullSecretsAnnotation] != serviceAccount.Annotations[attachedPullSecretsAnnotation] {
+				p.syncServiceAccount(serviceAccount)
+			}
+		},
+	}
+}
+
+func (p *PullSecretsController) syncServiceAccount(serviceAccount *corev1.ServiceAccount) {
+	secrets, err := p.clusterSecrets.List(serviceAccount.Namespace, labels.NewSelector())
+	if err != nil {
+		logrus.Errorf("Failed to list the secrets of service account [%s] in namespace [%s]: %v", serviceAccount.Name, serviceAccount.Namespace, err)
+		return
+	}
+	for _, secret := range secrets {
+		if isPullSecretCopy(secret) {
+			p.clusterSecretsClient.Controller().Enqueue(secret.Namespace, secret.Name)
+		}
+	}
+}
+
+// getServiceAccount returns the service account the copy should be attached to, empty if none
+func (p *PullSecretsController) getServiceAccount(secret *corev1.Secret) (string, error) {
+	if serviceAccount, ok := secret.Annotations[imagePullServiceAccountAnnotation]; ok {
+		return serviceAccountOrDefault(serviceAccount), nil
+	}
+
+	namespace, err := p.clusterNamespaceLister.Get("", secret.Namespace)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	// field.cattle.io/projectId value is <cluster name>:<project name>
+	parts := strings.Split(namespace.Annotations[projectIDLabel], ":")
+	if len(parts) != 2 || parts[0] != p.clusterName {
+		return "", nil
+	}
+	project, err := p.projectLister.Get(p.clusterName, parts[1])
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if serviceAccount, ok := project.Annotations[imagePullServiceAccountAnnotation]; ok {
+		return serviceAccountOrDefault(serviceAccount), nil
+	}
+	return "", nil
+}
+
+// attach makes the secret an imagePullSecret of the service account only, detaching it from the
+// other service accounts it was attached to. An empty service account detaches it everywhere
+func (p *PullSecretsController) attach(namespace, secretName, serviceAccountName string) error {
+	if !p.serviceAccountsSynced() {
+		return errors.New("service accounts aren't synced yet")
+	}
+	serviceAccounts, err := p.serviceAccounts.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, obj := range serviceAccounts {
+		serviceAccount := obj.(*corev1.ServiceAccount)
+		attached := attachedPullSecrets(serviceAccount)
+		switch {
+		case serviceAccount.Name == serviceAccountName:
+			found = true
+			// a reference added by users is left to them, and not recorded as attached by the agent
+			if hasPullSecret(serviceAccount, secretName) {
+				continue
+			}
+			if err := p.updateServiceAccount(serviceAccount, secretName, true); err != nil {
+				return err
+			}
+		case attached[secretName]:
+			if err := p.updateServiceAccount(serviceAccount, secretName, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if serviceAccountName != "" && !found {
+		// the default service account is created shortly after the namespace, retry until it is
+		return errors.Errorf("service account [%s] not found in namespace [%s]", serviceAccountName, namespace)
+	}
+	return nil
+}
+
+func (p *PullSecretsController) updateServiceAccount(serviceAccount *corev1.ServiceAccount, secretName string, attach bool) error {
+	serviceAccount = serviceAccount.DeepCopy()
+	attached := attachedPullSecrets(serviceAccount)
+
+	var pullSecrets []corev1.LocalObjectReference
+	for _, ref := range serviceAccount.ImagePullSecrets {
+		if ref.Name != secretName {
+			pullSecrets = append(pullSecrets, ref)
+		}
+	}
+	if attach {
+		pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: secretName})
+		attached[secretName] = true
+	} else {
+		delete(attached, secretName)
+	}
+	serviceAccount.ImagePullSecrets = pullSecrets
+	setAttachedPullSecrets(serviceAccount, attached)
+
+	if attach {
+		logrus.Infof("Attaching image pull secret [%s] to service account [%s] in namespace [%s]", secretName, serviceAccount.Name, serviceAccount.Namespace)
+	} else {
+		logrus.Infof("Detaching image pull secret [%s] from service account [%s] in namespace [%s]", secretName, serviceAccount.Name, serviceAccount.Namespace)
+	}
+	updated, err := p.k8sClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Update(serviceAccount)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update service account [%s] in namespace [%s]", serviceAccount.Name, serviceAccount.Namespace)
+	}
+	if attach {
+		p.events.Eventf(updated, corev1.EventTypeNormal, reasonPullSecretAttached, "Added image pull secret [%s]", secretName)
+	} else {
+		p.events.Eventf(updated, corev1.EventTypeNormal, reasonPullSecretDetached, "Removed image pull secret [%s]", secretName)
+	}
+	return nil
+}
+
+func hasPullSecret(serviceAccount *corev1.ServiceAccount, secretName string) bool {
+	for _, ref := range serviceAccount.ImagePullSecrets {
+		if ref.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+// isPullSecretCopy returns whether the secret is a copy of registry credentials
+func isPullSecretCopy(secret *corev1.Secret) bool {
+	return isCopy(secret) && secret.Type == corev1.SecretTypeDockerConfigJson
+}
+
+func serviceAccountOrDefault(serviceAccount string) string {
+	if serviceAccount == "" {
+		return defaultServiceAccount
+	}
+	return serviceAccount
+}
+
+func attachedPullSecrets(serviceAccount *corev1.ServiceAccount) map[string]bool {
+	attached := map[string]bool{}
+	for _, name := range strings.Split(serviceAccount.Annotations[attachedPullSecretsAnnotation], ",") {
+		if name != "" {
+			attached[name] = true
+		}
+	}
+	return attached
+}
+
+func setAttachedPullSecrets(serviceAccount *corev1.ServiceAccount, attached map[string]bool) {
+	if len(attached) == 0 {
+		delete(serviceAccount.Annotations, attachedPullSecretsAnnotation)
+		return
+	}
+	names := make([]string, 0, len(attached))
+	for name := range attached {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if serviceAccount.Annotations == nil {
+		serviceAccount.Annotations = map[string]string{}
+	}
+	serviceAccount.Annotations[attachedPullSecretsAnnotation] = strings.Join(names, ",")
+}
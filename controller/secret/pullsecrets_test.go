@@ -0,0 +1,223 @@
+package secret
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSyncProjectEnqueuesProjectCopies(t *testing.T) {
+	pullSecret := func(namespace, name string) *corev1.Secret {
+		secret := newSecret(namespace, name, map[string]string{sourceProjectLabel: "p-1"})
+		secret.Type = corev1.SecretTypeDockerConfigJson
+		return secret
+	}
+	client := &fakeSecretsClient{}
+	p := &PullSecretsController{
+		clusterSecrets: &fakeSecrets{secrets: []*corev1.Secret{
+			pullSecret("ns-1", "registry"),
+			newSecret("ns-1", "opaque", map[string]string{sourceProjectLabel: "p-1"}),
+			pullSecret("ns-2", "registry"),
+			pullSecret("other-project", "registry"),
+			pullSecret("other-cluster", "registry"),
+		}},
+		clusterSecretsClient: client,
+		clusterNamespaceLister: &fakeNamespaces{namespaces: []*corev1.Namespace{
+			newNamespace("ns-1", map[string]string{projectIDLabel: "c-1:p-1"}),
+			newNamespace("ns-2", map[string]string{projectIDLabel: "c-1:p-1"}),
+			newNamespace("other-project", map[string]string{projectIDLabel: "c-1:p-2"}),
+			newNamespace("other-cluster", map[string]string{projectIDLabel: "c-2:p-1"}),
+		}},
+		clusterName: "c-1",
+	}
+
+	if err := p.syncProject("c-1/p-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(client.controller.enqueued)
+	want := []string{"ns-1/registry", "ns-2/registry"}
+	if !reflect.DeepEqual(client.controller.enqueued, want) {
+		t.Errorf("got enqueued %v, want %v", client.controller.enqueued, want)
+	}
+}
+
+// serviceAccountsServer echoes the service accounts updated
+type serviceAccountsServer struct {
+	lock    sync.Mutex
+	updated []string
+}
+
+func (s *serviceAccountsServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	s.updated = append(s.updated, req.URL.Path)
+	s.lock.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	io.Copy(rw, req.Body)
+}
+
+func newServiceAccount(name string, attached ...string) *corev1.ServiceAccount {
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: name}}
+	names := map[string]bool{}
+	for _, secretName := range attached {
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+		names[secretName] = true
+	}
+	setAttachedPullSecrets(serviceAccount, names)
+	return serviceAccount
+}
+
+// newManualServiceAccount returns a service account referencing the secrets without the agent having attached them
+func newManualServiceAccount(name string, secretNames ...string) *corev1.ServiceAccount {
+	serviceAccount := newServiceAccount(name)
+	for _, secretName := range secretNames {
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+	}
+	return serviceAccount
+}
+
+func TestAttach(t *testing.T) {
+	tests := []struct {
+		name            string
+		synced          bool
+		serviceAccounts []*corev1.ServiceAccount
+		serviceAccount  string
+		wantUpdated     []string
+		wantErr         bool
+	}{
+		{
+			name:            "attach",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default"), newServiceAccount("builder")},
+			serviceAccount:  "default",
+			wantUpdated:     []string{"/api/v1/namespaces/ns-1/serviceaccounts/default"},
+		},
+		{
+			name:            "move",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default"), newServiceAccount("builder", "registry")},
+			serviceAccount:  "default",
+			wantUpdated:     []string{"/api/v1/namespaces/ns-1/serviceaccounts/builder", "/api/v1/namespaces/ns-1/serviceaccounts/default"},
+		},
+		{
+			name:            "already attached",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default", "registry")},
+			serviceAccount:  "default",
+		},
+		{
+			name:            "manually referenced",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newManualServiceAccount("default", "registry")},
+			serviceAccount:  "default",
+		},
+		{
+			name:   "attached reference removed by users",
+			synced: true,
+			serviceAccounts: []*corev1.ServiceAccount{func() *corev1.ServiceAccount {
+				serviceAccount := newServiceAccount("default", "registry")
+				serviceAccount.ImagePullSecrets = nil
+				return serviceAccount
+			}()},
+			serviceAccount: "default",
+			wantUpdated:    []string{"/api/v1/namespaces/ns-1/serviceaccounts/default"},
+		},
+		{
+			name:            "detach",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default", "registry")},
+			wantUpdated:     []string{"/api/v1/namespaces/ns-1/serviceaccounts/default"},
+		},
+		{
+			name:            "manual reference is not detached",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default"), newManualServiceAccount("builder", "registry")},
+			serviceAccount:  "default",
+			wantUpdated:     []string{"/api/v1/namespaces/ns-1/serviceaccounts/default"},
+		},
+		{
+			name:            "service account not found",
+			synced:          true,
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("builder")},
+			serviceAccount:  "default",
+			wantErr:         true,
+		},
+		{
+			name:            "not synced",
+			serviceAccounts: []*corev1.ServiceAccount{newServiceAccount("default")},
+			serviceAccount:  "default",
+			wantErr:         true,
+		},
+	}
+
+	for _, test := range tests {
+		handler := &serviceAccountsServer{}
+		server := httptest.NewServer(handler)
+		k8sClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, serviceAccount := range test.serviceAccounts {
+			indexer.Add(serviceAccount)
+		}
+		synced := test.synced
+		p := &PullSecretsController{
+			k8sClient:             k8sClient,
+			serviceAccounts:       indexer,
+			serviceAccountsSynced: func() bool { return synced },
+			events:                record.NewFakeRecorder(10),
+		}
+
+		err = p.attach("ns-1", "registry", test.serviceAccount)
+		server.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		sort.Strings(handler.updated)
+		if len(handler.updated) != len(test.wantUpdated) || (len(test.wantUpdated) > 0 && !reflect.DeepEqual(handler.updated, test.wantUpdated)) {
+			t.Errorf("%s: got updated %v, want %v", test.name, handler.updated, test.wantUpdated)
+		}
+	}
+}
+
+func TestServiceAccountHandler(t *testing.T) {
+	pullSecret := newSecret("ns-1", "registry", map[string]string{sourceProjectLabel: "p-1"})
+	pullSecret.Type = corev1.SecretTypeDockerConfigJson
+	client := &fakeSecretsClient{}
+	p := &PullSecretsController{
+		clusterSecrets: &fakeSecrets{secrets: []*corev1.Secret{
+			pullSecret,
+			newSecret("ns-1", "opaque", map[string]string{sourceProjectLabel: "p-1"}),
+			newSecret("ns-1", "users", nil),
+		}},
+		clusterSecretsClient: client,
+	}
+	handler := p.serviceAccountHandler()
+
+	handler.OnAdd(newServiceAccount("default"))
+	if want := []string{"ns-1/registry"}; !reflect.DeepEqual(client.controller.enqueued, want) {
+		t.Errorf("got enqueued %v on add, want %v", client.controller.enqueued, want)
+	}
+
+	client.controller.enqueued = nil
+	handler.OnUpdate(newServiceAccount("default", "registry"), newServiceAccount("default", "registry"))
+	if len(client.controller.enqueued) != 0 {
+		t.Errorf("got enqueued %v for an unchanged service account", client.controller.enqueued)
+	}
+
+	handler.OnUpdate(newServiceAccount("default", "registry"), newManualServiceAccount("default"))
+	if want := []string{"ns-1/registry"}; !reflect.DeepEqual(client.controller.enqueued, want) {
+		t.Errorf("got enqueued %v once the reference is removed, want %v", client.controller.enqueued, want)
+	}
+}
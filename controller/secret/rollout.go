@@ -0,0 +1,227 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	appsv1beta2 "github.com/rancher/types/apis/apps/v1beta2"
+	"github.com/rancher/types/apis/core/v1"
+	"github.com/sirupsen/logrus"
+	k8sappsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// rolloutAnnotation opts a secret, or a workload, in to rolling out the workloads referencing the
+	// secret when its copy changes. On a secret it is carried over to the copies
+	rolloutAnnotation = "secret.cluster.cattle.io/rollout"
+	// checksumAnnotation is set on the pod template to the checksum of the copies it references,
+	// changing it rolls the workload out
+	checksumAnnotation = "secret.cluster.cattle.io/checksum"
+	// rolledOutAnnotation records on a copy the content hash the workloads referencing it were rolled
+	// out for, a copy without it was rolled out for its content
+	rolledOutAnnotation = "secret.cluster.cattle.io/rolled-out-hash"
+
+	reasonRolledOut     = "SecretRolledOut"
+	reasonRolloutFailed = "SecretRolloutFailed"
+)
+
+// Rollout rolls out the Deployments, StatefulSets and DaemonSets referencing a secret copy that
+// changed, so their pods pick up the new content
+type Rollout struct {
+	k8sClient      kubernetes.Interface
+	secrets        v1.SecretInterface
+	clusterSecrets v1.SecretLister
+	deployments    appsv1beta2.DeploymentLister
+	statefulSets   cache.Indexer
+	daemonSets     cache.Indexer
+	synced         []cache.InformerSynced
+	events         record.EventRecorder
+}
+
+// rolledOutHash returns the content hash the workloads referencing the copy were rolled out for
+func rolledOutHash(secret *corev1.Secret) string {
+	if hash, ok := secret.Annotations[rolledOutAnnotation]; ok {
+		return hash
+	}
+	return secret.Annotations[contentHashAnnotation]
+}
+
+// carryOverRolledOut keeps the content hash the workloads were rolled out for on the update of a
+// copy, the workloads are rolled out once the update is written
+func carryOverRolledOut(copy, existing projectcopy.Object) {
+	annotations := copy.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[rolledOutAnnotation] = rolledOutHash(existing.(*corev1.Secret))
+	copy.SetAnnotations(annotations)
+}
+
+// rollout rolls out the opted in workloads of the namespace of the copy that reference it, unless
+// they already were for its content, and records it on the copy. It fails if any workload couldn't
+// be rolled out, the workloads rolled out already are skipped when it is retried
+func (r *Rollout) rollout(secret *corev1.Secret) error {
+	hash := secret.Annotations[contentHashAnnotation]
+	if rolledOutHash(secret) == hash {
+		return nil
+	}
+	for _, synced := range r.synced {
+		if !synced() {
+			return errors.New("workloads aren't synced yet")
+		}
+	}
+
+	optedIn := secret.Annotations[rolloutAnnotation] == "true"
+	apps := r.k8sClient.AppsV1beta2()
+	var errs []error
+
+	deployments, err := r.deployments.List(secret.Namespace, labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments {
+		deployment = deployment.DeepCopy()
+		if !r.bump(secret, optedIn, &deployment.ObjectMeta, &deployment.Spec.Template) {
+			continue
+		}
+		_, err := apps.Deployments(deployment.Namespace).Update(deployment)
+		errs = r.record(errs, deployment, secret, err)
+	}
+
+	statefulSets, err := r.statefulSets.ByIndex(cache.NamespaceIndex, secret.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, obj := range statefulSets {
+		statefulSet := obj.(*k8sappsv1beta2.StatefulSet).DeepCopy()
+		if !r.bump(secret, optedIn, &statefulSet.ObjectMeta, &statefulSet.Spec.Template) {
+			continue
+		}
+		_, err := apps.StatefulSets(statefulSet.Namespace).Update(statefulSet)
+		errs = r.record(errs, statefulSet, secret, err)
+	}
+
+	daemonSets, err := r.daemonSets.ByIndex(cache.NamespaceIndex, secret.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, obj := range daemonSets {
+		daemonSet := obj.(*k8sappsv1beta2.DaemonSet).DeepCopy()
+		if !r.bump(secret, optedIn, &daemonSet.ObjectMeta, &daemonSet.Spec.Template) {
+			continue
+		}
+		_, err := apps.DaemonSets(daemonSet.Namespace).Update(daemonSet)
+		errs = r.record(errs, daemonSet, secret, err)
+	}
+
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	rolledOut := secret.DeepCopy()
+	rolledOut.Annotations[rolledOutAnnotation] = hash
+	_, err = r.secrets.Update(rolledOut)
+	return err
+}
+
+// bump sets the checksum annotation of the pod template of an opted in workload referencing the
+// secret, and returns whether it changed
+func (r *Rollout) bump(secret *corev1.Secret, optedIn bool, workload *metav1.ObjectMeta, template *corev1.PodTemplateSpec) bool {
+	if !optedIn && workload.Annotations[rolloutAnnotation] != "true" {
+		return false
+	}
+	names := referencedSecrets(&template.Spec)
+	if !names[secret.Name] {
+		return false
+	}
+
+	checksum := r.checksum(secret, names, workload.Namespace)
+	if template.Annotations[checksumAnnotation] == checksum {
+		return false
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[checksumAnnotation] = checksum
+	return true
+}
+
+// checksum hashes the content of the copies the pod template references. The changed copy is taken
+// as written, the cache may not have it yet
+func (r *Rollout) checksum(changed *corev1.Secret, names map[string]bool, namespace string) string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	hash := sha256.New()
+	for _, name := range sorted {
+		secret := changed
+		if name != changed.Name {
+			var err error
+			if secret, err = r.clusterSecrets.Get(namespace, name); err != nil || !isCopy(secret) {
+				continue
+			}
+		}
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write([]byte(secret.Annotations[contentHashAnnotation]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// record reports the roll out of the workload, and returns the errors with its error if it failed
+func (r *Rollout) record(errs []error, workload runtime.Object, secret *corev1.Secret, err error) []error {
+	if err != nil {
+		logrus.Errorf("Failed to roll out workload for secret [%s] in namespace [%s]: %v", secret.Name, secret.Namespace, err)
+		r.events.Eventf(workload, corev1.EventTypeWarning, reasonRolloutFailed, "Failed to roll out for changed secret [%s]: %v", secret.Name, err)
+		return append(errs, err)
+	}
+	r.events.Eventf(workload, corev1.EventTypeNormal, reasonRolledOut, "Rolled out for changed secret [%s]", secret.Name)
+	return errs
+}
+
+// referencedSecrets returns the names of the secrets the pod spec reads through volumes or the
+// environment of its containers
+func referencedSecrets(spec *corev1.PodSpec) map[string]bool {
+	names := map[string]bool{}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					names[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			for _, envFrom := range container.EnvFrom {
+				if envFrom.SecretRef != nil {
+					names[envFrom.SecretRef.Name] = true
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+					names[env.ValueFrom.SecretKeyRef.Name] = true
+				}
+			}
+		}
+	}
+	return names
+}
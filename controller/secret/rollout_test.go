@@ -0,0 +1,100 @@
+package secret
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	k8sappsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// statefulSetsServer fails the first updates of stateful sets and accepts the later ones
+type statefulSetsServer struct {
+	failures int
+	updates  int
+}
+
+func (s *statefulSetsServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodPut {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.updates++
+	if s.updates <= s.failures {
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "code": 500}`))
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	rw.Write(body)
+}
+
+func newStatefulSet(namespace, name, secretName string) *k8sappsv1beta2.StatefulSet {
+	statefulSet := &k8sappsv1beta2.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	statefulSet.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "creds",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secretName}},
+	}}
+	return statefulSet
+}
+
+func TestRollout(t *testing.T) {
+	tests := []struct {
+		name          string
+		rolledOutHash string
+		failures      int
+		wantUpdates   int
+		wantRolledOut bool
+	}{
+		{name: "changed copy", rolledOutHash: "old", wantUpdates: 1, wantRolledOut: true},
+		{name: "failed roll out", rolledOutHash: "old", failures: 1, wantUpdates: 1},
+		{name: "rolled out copy", rolledOutHash: "new"},
+	}
+
+	for _, test := range tests {
+		workloads := &statefulSetsServer{failures: test.failures}
+		server := httptest.NewServer(workloads)
+		k8sClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &fakeSecretsClient{}
+		r := &Rollout{
+			k8sClient:      k8sClient,
+			secrets:        client,
+			clusterSecrets: &fakeSecrets{},
+			deployments:    &fakeDeployments{},
+			statefulSets:   newWorkloadIndexer(newStatefulSet("ns-1", "web", "creds"), newStatefulSet("ns-2", "web", "creds")),
+			daemonSets:     newWorkloadIndexer(),
+			events:         record.NewFakeRecorder(10),
+		}
+		secret := newSecret("ns-1", "creds", nil)
+		secret.Annotations = map[string]string{
+			contentHashAnnotation: "new",
+			rolledOutAnnotation:   test.rolledOutHash,
+			rolloutAnnotation:     "true",
+		}
+
+		err = r.rollout(secret)
+		server.Close()
+		if test.failures > 0 && err == nil {
+			t.Errorf("%s: got no error", test.name)
+		} else if test.failures == 0 && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if workloads.updates != test.wantUpdates {
+			t.Errorf("%s: got %d workload updates, want %d", test.name, workloads.updates, test.wantUpdates)
+		}
+		// the copy records the roll out only once every workload was rolled out, a failed one is retried
+		if rolledOut := len(client.updated) == 1 && client.updated[0].Annotations[rolledOutAnnotation] == "new"; rolledOut != test.wantRolledOut {
+			t.Errorf("%s: got updated copies %v, want rolled out %v", test.name, client.updated, test.wantRolledOut)
+		}
+	}
+}
@@ -1,16 +1,24 @@
 package secret
 
 import (
-	"strings"
+	"context"
+	"fmt"
 
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/cluster-agent/utils"
+	"github.com/rancher/norman/controller"
 	"github.com/rancher/types/apis/core/v1"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
+	projectv3 "github.com/rancher/types/apis/project.cattle.io/v3"
 	"github.com/rancher/types/config"
 	"github.com/sirupsen/logrus"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 // SecretController listens for secret CUD in management API
@@ -21,174 +29,386 @@ import (
 // and creates the secrets in the cluster namespace
 
 const (
-	projectIDLabel             = "field.cattle.io/projectId"
-	create                     = "create"
-	update                     = "update"
-	projectNamespaceAnnotation = "management.cattle.io/system-namespace"
+	projectIDLabel             = projectcopy.ProjectIDAnnotation
+	projectNamespaceAnnotation = projectcopy.ProjectNamespaceAnnotation
+	// sourceProjectLabel records the project a secret was copied from
+	sourceProjectLabel = "secret.cluster.cattle.io/source-project"
+
+	reasonPropagated        = "SecretPropagated"
+	reasonPropagationFailed = "SecretPropagationFailed"
+	reasonRemoved           = "SecretRemoved"
+	reasonConflict          = "SecretConflict"
 )
 
 type Controller struct {
 	secrets                   v1.SecretInterface
+	clusterSecrets            v1.SecretLister
+	managementSecrets         v1.SecretLister
 	clusterNamespaceLister    v1.NamespaceLister
 	managementNamespaceLister v1.NamespaceLister
 	projectLister             v3.ProjectLister
 	clusterName               string
+	events                    record.EventRecorder
+	// sources get the secret a copy is made from by kind, plain secrets having no kind
+	sources    map[string]func(namespace, name string) (*corev1.Secret, error)
+	rollout    *Rollout
+	encryption *Encryption
 }
 
-func Register(cluster *config.ClusterContext) {
+func Register(ctx context.Context, cluster *config.ClusterContext, opts Options, events *utils.Recorders) error {
 	clusterSecretsClient := cluster.Core.Secrets("")
+	encryption, err := newEncryption(opts.KeySecret, clusterSecretsClient, cluster.Management.Management.Clusters(""),
+		cluster.Management.Management.RESTClient(), cluster.ClusterName, events.Management)
+	if err != nil {
+		return err
+	}
+
+	// project credentials are served by management, but the management context has no client for them
+	managementProject, err := projectv3.NewForConfig(cluster.Management.RESTConfig)
+	if err != nil {
+		return err
+	}
+
+	// the cluster context has no controllers for stateful sets and daemon sets
+	deployments := cluster.Apps.Deployments("").Controller()
+	statefulSets := utils.NewInformer(cluster.K8sClient.AppsV1beta2().RESTClient(), "statefulsets", &appsv1beta2.StatefulSet{})
+	go statefulSets.Run(ctx.Done())
+	daemonSets := utils.NewInformer(cluster.K8sClient.AppsV1beta2().RESTClient(), "daemonsets", &appsv1beta2.DaemonSet{})
+	go daemonSets.Run(ctx.Done())
+
+	managementSecrets := cluster.Management.Core.Secrets("").Controller().Lister()
 	s := &Controller{
 		secrets:                   clusterSecretsClient,
+		clusterSecrets:            clusterSecretsClient.Controller().Lister(),
+		managementSecrets:         managementSecrets,
 		clusterNamespaceLister:    cluster.Core.Namespaces("").Controller().Lister(),
 		managementNamespaceLister: cluster.Management.Core.Namespaces("").Controller().Lister(),
 		projectLister:             cluster.Management.Management.Projects("").Controller().Lister(),
 		clusterName:               cluster.ClusterName,
+		events:                    events.Cluster,
+		sources: map[string]func(namespace, name string) (*corev1.Secret, error){
+			"": func(namespace, name string) (*corev1.Secret, error) {
+				secret, err := managementSecrets.Get(namespace, name)
+				if err != nil {
+					return nil, err
+				}
+				return encryption.decrypt(secret)
+			},
+		},
+		encryption: encryption,
+		rollout: &Rollout{
+			k8sClient:      cluster.K8sClient,
+			secrets:        clusterSecretsClient,
+			clusterSecrets: clusterSecretsClient.Controller().Lister(),
+			deployments:    deployments.Lister(),
+			statefulSets:   statefulSets.GetIndexer(),
+			daemonSets:     daemonSets.GetIndexer(),
+			synced:         []cache.InformerSynced{deployments.Informer().HasSynced, statefulSets.HasSynced, daemonSets.HasSynced},
+			events:         events.Cluster,
+		},
 	}
+	// the controllers of the cluster context wait for every cache to sync, namespaced credentials are
+	// left out when the cluster doesn't serve them
+	clusterProject := cluster.Project
+	served, err := waitForNamespacedCredentials(ctx, cluster.K8sClient.Discovery(), discoveryRetryInterval, discoveryMaxRetryInterval)
+	if err != nil {
+		return err
+	}
+	if !served {
+		logrus.Warnf("Namespaced credentials aren't served by cluster [%s], they won't be copied", cluster.ClusterName)
+		clusterProject = nil
+	}
+	credentials := newCredentialController(s, managementProject, clusterProject)
+	credentials.register(cluster.ClusterName, managementProject, clusterProject)
+	registerCertificates(cluster.ClusterName, managementProject, clusterProject)
 
 	n := &NamespaceController{
 		clusterSecretsClient: clusterSecretsClient,
-		managementSecrets:    cluster.Management.Core.Secrets("").Controller().Lister(),
+		clusterSecrets:       clusterSecretsClient.Controller().Lister(),
+		managementSecrets:    managementSecrets,
+		credentials:          credentials.listCredentials,
+		decrypt:              encryption.decrypt,
+		clusterName:          cluster.ClusterName,
+		events:               events.Cluster,
 	}
 	cluster.Core.Namespaces("").AddHandler("secretsController", n.sync)
 	cluster.Management.Core.Secrets("").AddClusterScopedLifecycle("secretsController", cluster.ClusterName, s)
+	clusterSecretsClient.AddHandler("secretsDriftController", s.syncCopy)
+
+	serviceAccounts := utils.NewInformer(cluster.K8sClient.CoreV1().RESTClient(), "serviceaccounts", &corev1.ServiceAccount{})
+	p := &PullSecretsController{
+		k8sClient:              cluster.K8sClient,
+		serviceAccounts:        serviceAccounts.GetIndexer(),
+		serviceAccountsSynced:  serviceAccounts.HasSynced,
+		clusterSecrets:         clusterSecretsClient.Controller().Lister(),
+		clusterSecretsClient:   clusterSecretsClient,
+		clusterNamespaceLister: cluster.Core.Namespaces("").Controller().Lister(),
+		projectLister:          cluster.Management.Management.Projects("").Controller().Lister(),
+		clusterName:            cluster.ClusterName,
+		events:                 events.Cluster,
+	}
+	clusterSecretsClient.AddHandler("imagePullSecretsController", p.sync)
+	serviceAccounts.AddEventHandler(p.serviceAccountHandler())
+	go serviceAccounts.Run(ctx.Done())
+	cluster.Management.Management.Projects("").AddClusterScopedHandler("imagePullSecretsController", cluster.ClusterName, p.syncProject)
+
+	c := &Collector{
+		secrets:        clusterSecretsClient,
+		clusterSecrets: clusterSecretsClient.Controller().Lister(),
+		getSource:      s.getSource,
+		projectLister:  cluster.Management.Management.Projects("").Controller().Lister(),
+		clusterName:    cluster.ClusterName,
+		events:         events.Cluster,
+		synced: append([]cache.InformerSynced{
+			clusterSecretsClient.Controller().Informer().HasSynced,
+			cluster.Management.Core.Secrets("").Controller().Informer().HasSynced,
+			cluster.Management.Management.Projects("").Controller().Informer().HasSynced,
+		}, credentials.synced...),
+	}
+	go c.collect(ctx, collectInterval)
+	if encryption != nil {
+		go encryption.run(ctx, keyRetryInterval, publishInterval)
+	}
+
+	return controller.SyncThenStart(ctx, 5, managementProject)
 }
 
 type NamespaceController struct {
 	clusterSecretsClient v1.SecretInterface
+	clusterSecrets       v1.SecretLister
 	managementSecrets    v1.SecretLister
+	credentials          func(projectName string) ([]*corev1.Secret, error)
+	decrypt              func(*corev1.Secret) (*corev1.Secret, error)
+	clusterName          string
+	events               record.EventRecorder
 }
 
 func (n *NamespaceController) sync(key string, obj *corev1.Namespace) error {
 	if obj == nil || obj.DeletionTimestamp != nil {
 		return nil
 	}
-	// field.cattle.io/projectId value is <cluster name>:<project name>
-	if obj.Annotations[projectIDLabel] != "" {
-		parts := strings.Split(obj.Annotations[projectIDLabel], ":")
-		if len(parts) == 2 {
-			// on the managemenet side, secret's namespace name equals to project name
-			secrets, err := n.managementSecrets.List(parts[1], labels.NewSelector())
-			if err != nil {
-				return err
-			}
-			for _, secret := range secrets {
-				namespacedSecret := &corev1.Secret{}
-				namespacedSecret.Name = secret.Name
-				namespacedSecret.Annotations = secret.Annotations
-				namespacedSecret.Data = secret.Data
-				namespacedSecret.StringData = secret.StringData
-				namespacedSecret.Type = secret.Type
-				namespacedSecret.Namespace = obj.Name
-				_, err := n.clusterSecretsClient.Create(namespacedSecret)
-				if err != nil && !errors.IsAlreadyExists(err) {
-					return err
-				}
-			}
+	projectName := projectcopy.ProjectName(obj)
+	if err := n.removeOtherProjectSecrets(obj, projectName); err != nil {
+		return err
+	}
+	if err := n.syncClusterWide(obj); err != nil {
+		return err
+	}
+	if projectName == "" {
+		return nil
+	}
+
+	// on the managemenet side, secret's namespace name equals to project name
+	secrets, err := n.managementSecrets.List(projectName, labels.NewSelector())
+	if err != nil {
+		return err
+	}
+	credentials, err := n.credentials(projectName)
+	if err != nil {
+		return err
+	}
+	for _, secret := range append(secrets, credentials...) {
+		if secret, err = n.decrypt(secret); err != nil {
+			n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy secret of project [%s]: %v", projectName, err)
+			return err
+		}
+		created, err := n.clusterSecretsClient.Create(copySecret(secret, obj.Name, n.clusterName))
+		if err != nil && !errors.IsAlreadyExists(err) {
+			n.events.Eventf(obj, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy secret [%s] of project [%s]: %v", secret.Name, projectName, err)
+			return err
+		}
+		if err == nil {
+			n.events.Eventf(created, corev1.EventTypeNormal, reasonPropagated, "Copied from project [%s]", projectName)
 		}
 	}
 	return nil
 }
 
+// removeOtherProjectSecrets deletes the secrets copied from projects the namespace is no longer in
+func (n *NamespaceController) removeOtherProjectSecrets(obj *corev1.Namespace, projectName string) error {
+	return newCopier(n.clusterSecretsClient, n.clusterSecrets, n.events).RemoveOtherProjectCopies(obj, projectName)
+}
+
+// copySecret returns the copy of a project or cluster-wide secret for a namespace it targets
+func copySecret(obj *corev1.Secret, namespace, clusterName string) *corev1.Secret {
+	namespacedSecret := &corev1.Secret{}
+	namespacedSecret.Name = obj.Name
+	namespacedSecret.Annotations = map[string]string{}
+	for key, value := range obj.Annotations {
+		namespacedSecret.Annotations[key] = value
+	}
+	namespacedSecret.Annotations[contentHashAnnotation] = contentHash(obj)
+	namespacedSecret.Labels = map[string]string{
+		sourceNamespaceLabel: obj.Namespace,
+		sourceUIDLabel:       string(obj.UID),
+	}
+	kind := obj.Labels[sourceKindLabel]
+	if kind != "" {
+		namespacedSecret.Labels[sourceKindLabel] = kind
+	}
+	switch {
+	case isClusterWide(obj, clusterName):
+		namespacedSecret.Labels[sourceClusterLabel] = clusterName
+	case !isNamespacedKind(kind):
+		// on the managemenet side, secret's namespace name equals to project name
+		namespacedSecret.Labels[sourceProjectLabel] = obj.Namespace
+	}
+	namespacedSecret.Kind = obj.Kind
+	namespacedSecret.Data = obj.Data
+	namespacedSecret.StringData = obj.StringData
+	namespacedSecret.Type = obj.Type
+	namespacedSecret.Namespace = namespace
+	return namespacedSecret
+}
+
 func (s *Controller) Create(obj *corev1.Secret) (*corev1.Secret, error) {
-	return nil, s.createOrUpdate(obj, create)
+	return nil, s.createOrUpdate(obj)
 }
 
 func (s *Controller) Updated(obj *corev1.Secret) (*corev1.Secret, error) {
-	return nil, s.createOrUpdate(obj, update)
+	return nil, s.createOrUpdate(obj)
 }
 
 func (s *Controller) Remove(obj *corev1.Secret) (*corev1.Secret, error) {
-	clusterNamespaces, err := s.getClusterNamespaces(obj)
+	// copies are removed even if the secret no longer opts in, it may have had copies before it did
+	if inClusterNamespace(obj, s.clusterName) {
+		return nil, s.removeClusterWideCopies(obj, nil)
+	}
+	clusterNamespaces, err := s.getTargetNamespaces(obj)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, namespace := range clusterNamespaces {
-		logrus.Infof("Deleting secret [%s] in namespace [%s]", obj.Name, namespace.Name)
-		if err := s.secrets.DeleteNamespaced(namespace.Name, obj.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		existing, err := s.clusterSecrets.Get(namespace.Name, obj.Name)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !isOwnedCopy(existing, obj) {
+			logrus.Infof("Not deleting secret [%s] in namespace [%s], it is not a copy of project [%s]", obj.Name, namespace.Name, obj.Namespace)
+			continue
+		}
+		if err := s.deleteCopy(existing); err != nil {
 			return nil, err
 		}
 	}
 	return nil, nil
 }
 
-func (s *Controller) getClusterNamespaces(obj *corev1.Secret) ([]*corev1.Namespace, error) {
-	var toReturn []*corev1.Namespace
-	projectNamespace, err := s.managementNamespaceLister.Get("", obj.Namespace)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			logrus.Warnf("Project namespace [%s] can't be found", obj.Namespace)
-			return toReturn, nil
-		}
-		return toReturn, err
+func (s *Controller) deleteCopy(secret *corev1.Secret) error {
+	logrus.Infof("Deleting secret [%s] in namespace [%s]", secret.Name, secret.Namespace)
+	if err := s.secrets.DeleteNamespaced(secret.Namespace, secret.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		s.events.Eventf(secret, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to delete copy of secret [%s]: %v", secret.Name, err)
+		return err
+	}
+	return nil
+}
+
+// getSource returns the secret of the kind a copy is made from, nil if it doesn't exist or is being deleted
+func (s *Controller) getSource(kind, namespace, name string) (*corev1.Secret, error) {
+	get, ok := s.sources[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret source kind [%s]", kind)
 	}
-	if projectNamespace.Annotations == nil {
-		return toReturn, nil
+	source, err := get(namespace, name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	if val, ok := projectNamespace.Annotations[projectNamespaceAnnotation]; !(ok && val == "true") {
-		return toReturn, nil
+	if source.DeletionTimestamp != nil {
+		return nil, nil
 	}
+	return source, nil
+}
 
-	// Ignore projects from other clusters. Project namespace name = project name, so use it to locate the project
-	_, err = s.projectLister.Get(s.clusterName, projectNamespace.Name)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			logrus.Warnf("Project [%s] can't be found", projectNamespace.Name)
-			return toReturn, nil
-		}
-		return toReturn, err
+// getTargetNamespaces returns the namespaces a secret is copied to. Namespaced credentials are
+// materialized in their own namespace, cluster-wide secrets in the namespaces they select, and
+// the others in the namespaces of their project
+func (s *Controller) getTargetNamespaces(obj *corev1.Secret) ([]*corev1.Namespace, error) {
+	if isClusterWide(obj, s.clusterName) {
+		return s.getClusterWideNamespaces(obj)
 	}
-
-	namespaces, err := s.clusterNamespaceLister.List("", labels.NewSelector())
-	if err != nil {
-		return toReturn, err
+	if !isNamespacedKind(obj.Labels[sourceKindLabel]) {
+		return s.getClusterNamespaces(obj)
 	}
-	// system project namespace name == project.Name
-	projectID := obj.Namespace
-
-	for _, namespace := range namespaces {
-		parts := strings.Split(namespace.Annotations[projectIDLabel], ":")
-		if len(parts) == 2 && parts[1] == projectID {
-			toReturn = append(toReturn, namespace)
-		}
+	namespace, err := s.clusterNamespaceLister.Get("", obj.Namespace)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	return toReturn, nil
+	return []*corev1.Namespace{namespace}, nil
 }
 
-func (s *Controller) createOrUpdate(obj *corev1.Secret, action string) error {
-	clusterNamespaces, err := s.getClusterNamespaces(obj)
+func (s *Controller) getClusterNamespaces(obj *corev1.Secret) ([]*corev1.Namespace, error) {
+	return projectcopy.ProjectNamespaces(s.clusterName, obj.Namespace, s.managementNamespaceLister, s.clusterNamespaceLister, s.projectLister)
+}
+
+func (s *Controller) createOrUpdate(obj *corev1.Secret) error {
+	// encrypted secrets are only decrypted here, when writing the copies
+	obj, err := s.encryption.decrypt(obj)
+	if err != nil {
+		return err
+	}
+	clusterNamespaces, err := s.getTargetNamespaces(obj)
 	if err != nil {
 		return err
 	}
 	for _, namespace := range clusterNamespaces {
-		// copy the secret into namespace
-		namespacedSecret := &corev1.Secret{}
-		namespacedSecret.Name = obj.Name
-		namespacedSecret.Annotations = obj.Annotations
-		namespacedSecret.Kind = obj.Kind
-		namespacedSecret.Data = obj.Data
-		namespacedSecret.StringData = obj.StringData
-		namespacedSecret.Type = obj.Type
-		namespacedSecret.Namespace = namespace.Name
-		switch action {
-		case create:
-			logrus.Infof("Copying secret [%s] into namespace [%s]", namespacedSecret.Name, namespace.Name)
-			_, err := s.secrets.Create(namespacedSecret)
-			if err != nil && !errors.IsAlreadyExists(err) {
-				return err
-			}
-		case update:
-			_, err := s.secrets.Update(namespacedSecret)
-			if err != nil && !errors.IsNotFound(err) {
-				return err
-			} else if errors.IsNotFound(err) {
-				_, err := s.secrets.Create(namespacedSecret)
-				if err != nil {
-					return err
-				}
-			}
+		written, err := s.ensureCopy(obj, namespace)
+		if err != nil {
+			s.events.Eventf(namespace, corev1.EventTypeWarning, reasonPropagationFailed, "Failed to copy secret [%s] of project [%s]: %v", obj.Name, obj.Namespace, err)
+			return err
+		}
+		if written == nil {
+			continue
+		}
+		if isClusterWide(obj, s.clusterName) {
+			s.events.Eventf(written, corev1.EventTypeNormal, reasonPropagated, "Copied from cluster [%s]", obj.Namespace)
+		} else {
+			s.events.Eventf(written, corev1.EventTypeNormal, reasonPropagated, "Copied from project [%s]", obj.Namespace)
 		}
 	}
 
+	if inClusterNamespace(obj, s.clusterName) {
+		// the selector may have changed or the secret may no longer opt in, the namespaces no longer
+		// selected lose their copy
+		return s.removeClusterWideCopies(obj, clusterNamespaces)
+	}
 	return nil
 }
+
+// ensureCopy creates or updates the copy of the secret in the namespace, and returns it unless it
+// was already up to date. A secret of the same name the agent doesn't own is never overwritten
+func (s *Controller) ensureCopy(obj *corev1.Secret, namespace *corev1.Namespace) (*corev1.Secret, error) {
+	owned := func(existing projectcopy.Object) bool {
+		return isOwnedCopy(existing.(*corev1.Secret), obj)
+	}
+	upToDate := func(existing projectcopy.Object) bool {
+		return isUpToDate(existing.(*corev1.Secret), obj) && existing.GetLabels()[sourceUIDLabel] == string(obj.UID)
+	}
+	written, existing, err := newCopier(s.secrets, s.clusterSecrets, s.events).EnsureCopy(obj, namespace, copySecret(obj, namespace.Name, s.clusterName), owned, upToDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// the workloads are rolled out for the content of the copy, also when the copy is up to date and
+	// an earlier roll out failed
+	current := written
+	if current == nil {
+		if existing == nil || !owned(existing) {
+			return nil, nil
+		}
+		current = existing
+	}
+	if err := s.rollout.rollout(current.(*corev1.Secret)); err != nil {
+		return nil, err
+	}
+	if written == nil {
+		return nil, nil
+	}
+	return written.(*corev1.Secret), nil
+}
@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/rancher/types/apis/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeSecrets struct {
+	v1.SecretLister
+	secrets []*corev1.Secret
+}
+
+func (f *fakeSecrets) Get(namespace, name string) (*corev1.Secret, error) {
+	for _, secret := range f.secrets {
+		if secret.Namespace == namespace && secret.Name == name {
+			return secret, nil
+		}
+	}
+	return nil, errors.NewNotFound(corev1.Resource("secrets"), name)
+}
+
+func (f *fakeSecrets) List(namespace string, selector labels.Selector) ([]*corev1.Secret, error) {
+	var result []*corev1.Secret
+	for _, secret := range f.secrets {
+		if (namespace == "" || secret.Namespace == namespace) && selector.Matches(labels.Set(secret.Labels)) {
+			result = append(result, secret)
+		}
+	}
+	return result, nil
+}
+
+type fakeSecretsClient struct {
+	v1.SecretInterface
+	created    []*corev1.Secret
+	updated    []*corev1.Secret
+	deleted    []string
+	controller fakeSecretController
+}
+
+func (f *fakeSecretsClient) Controller() v1.SecretController {
+	return &f.controller
+}
+
+type fakeSecretController struct {
+	v1.SecretController
+	enqueued []string
+}
+
+func (f *fakeSecretController) Enqueue(namespace, name string) {
+	f.enqueued = append(f.enqueued, namespace+"/"+name)
+}
+
+func (f *fakeSecretsClient) Create(secret *corev1.Secret) (*corev1.Secret, error) {
+	f.created = append(f.created, secret)
+	return secret, nil
+}
+
+func (f *fakeSecretsClient) Update(secret *corev1.Secret) (*corev1.Secret, error) {
+	f.updated = append(f.updated, secret)
+	return secret, nil
+}
+
+func (f *fakeSecretsClient) DeleteNamespaced(namespace, name string, options *metav1.DeleteOptions) error {
+	f.deleted = append(f.deleted, namespace+"/"+name)
+	return nil
+}
+
+func newSecret(namespace, name string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"key": []byte(name)},
+	}
+}
+
+func TestRemoveOtherProjectSecrets(t *testing.T) {
+	secrets := &fakeSecrets{secrets: []*corev1.Secret{
+		newSecret("ns-1", "old-project", map[string]string{sourceProjectLabel: "p-1", sourceNamespaceLabel: "p-1"}),
+		newSecret("ns-1", "new-project", map[string]string{sourceProjectLabel: "p-2", sourceNamespaceLabel: "p-2"}),
+		newSecret("ns-1", "user", nil),
+		newSecret("ns-1", "empty-label", map[string]string{sourceProjectLabel: ""}),
+		newSecret("ns-1", "cluster-wide", map[string]string{sourceClusterLabel: "c-1", sourceNamespaceLabel: "c-1"}),
+		newSecret("ns-1", "namespaced-credential", map[string]string{sourceKindLabel: namespacedBasicAuthKind, sourceNamespaceLabel: "ns-1"}),
+		newSecret("ns-2", "other-namespace", map[string]string{sourceProjectLabel: "p-1", sourceNamespaceLabel: "p-1"}),
+	}}
+
+	tests := []struct {
+		name        string
+		projectName string
+		want        []string
+	}{
+		{name: "moved to another project", projectName: "p-2", want: []string{"ns-1/old-project"}},
+		{name: "still in the project", projectName: "p-1", want: []string{"ns-1/new-project"}},
+		{name: "removed from the project", want: []string{"ns-1/new-project", "ns-1/old-project"}},
+	}
+
+	for _, test := range tests {
+		client := &fakeSecretsClient{}
+		n := &NamespaceController{
+			clusterSecretsClient: client,
+			clusterSecrets:       secrets,
+			clusterName:          "c-1",
+			events:               record.NewFakeRecorder(10),
+		}
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-1"}}
+
+		if err := n.removeOtherProjectSecrets(namespace, test.projectName); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		sort.Strings(client.deleted)
+		if len(client.deleted) != len(test.want) {
+			t.Errorf("%s: got deleted %v, want %v", test.name, client.deleted, test.want)
+			continue
+		}
+		for i := range test.want {
+			if client.deleted[i] != test.want[i] {
+				t.Errorf("%s: got deleted %v, want %v", test.name, client.deleted, test.want)
+				break
+			}
+		}
+	}
+}
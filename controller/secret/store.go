@@ -0,0 +1,77 @@
+package secret
+
+import (
+	"github.com/rancher/cluster-agent/controller/projectcopy"
+	"github.com/rancher/types/apis/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+)
+
+// secretStore reads the secrets of the cluster from the cache and writes them with the client
+type secretStore struct {
+	client v1.SecretInterface
+	lister v1.SecretLister
+}
+
+func (s *secretStore) Get(namespace, name string) (projectcopy.Object, error) {
+	secret, err := s.lister.Get(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *secretStore) List(namespace string) ([]projectcopy.Object, error) {
+	secrets, err := s.lister.List(namespace, labels.NewSelector())
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]projectcopy.Object, 0, len(secrets))
+	for _, secret := range secrets {
+		objs = append(objs, secret)
+	}
+	return objs, nil
+}
+
+func (s *secretStore) GetLive(namespace, name string) (projectcopy.Object, error) {
+	secret, err := s.client.GetNamespaced(namespace, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *secretStore) Create(obj projectcopy.Object) (projectcopy.Object, error) {
+	secret, err := s.client.Create(obj.(*corev1.Secret))
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *secretStore) Update(obj projectcopy.Object) (projectcopy.Object, error) {
+	secret, err := s.client.Update(obj.(*corev1.Secret))
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *secretStore) Delete(namespace, name string) error {
+	return s.client.DeleteNamespaced(namespace, name, &metav1.DeleteOptions{})
+}
+
+func newCopier(client v1.SecretInterface, lister v1.SecretLister, events record.EventRecorder) *projectcopy.Copier {
+	return &projectcopy.Copier{
+		Kind:                    "secret",
+		Store:                   &secretStore{client: client, lister: lister},
+		SourceProjectLabel:      sourceProjectLabel,
+		CarryOver:               carryOverRolledOut,
+		Events:                  events,
+		ReasonRemoved:           reasonRemoved,
+		ReasonPropagationFailed: reasonPropagationFailed,
+		ReasonConflict:          reasonConflict,
+	}
+}
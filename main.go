@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	_ "expvar"
+	"net/http"
 	"os"
+	"time"
 
 	controller "github.com/rancher/cluster-agent/controller"
+	"github.com/rancher/cluster-agent/controller/eventssyncer"
 	"github.com/rancher/types/config"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -12,6 +16,8 @@ import (
 )
 
 func main() {
+	opts := &controller.Options{}
+
 	app := cli.NewApp()
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
@@ -26,13 +32,104 @@ func main() {
 			Name:  "cluster-name",
 			Usage: "name of the cluster",
 		},
+		cli.DurationFlag{
+			Name:        "machine-grace-period",
+			Usage:       "how long to keep a machine after its node is gone from the cluster",
+			Value:       5 * time.Minute,
+			Destination: &opts.NodeSyncer.MachineGracePeriod,
+		},
+		cli.DurationFlag{
+			Name:        "usage-refresh-interval",
+			Usage:       "how often to read node usage from the metrics API, 0 to disable",
+			Value:       time.Minute,
+			Destination: &opts.NodeSyncer.UsageRefreshInterval,
+		},
+		cli.DurationFlag{
+			Name:        "machine-update-interval",
+			Usage:       "minimum time between two updates of a machine, node condition and taint changes are sent right away",
+			Value:       15 * time.Second,
+			Destination: &opts.NodeSyncer.MachineUpdateInterval,
+		},
+		cli.DurationFlag{
+			Name:        "event-update-interval",
+			Usage:       "minimum time between two updates of a cluster event for an event that keeps repeating",
+			Value:       30 * time.Second,
+			Destination: &opts.EventsSyncer.EventUpdateInterval,
+		},
+		cli.DurationFlag{
+			Name:        "event-ttl",
+			Usage:       "how long to keep a cluster event after the event was last seen, 0 to keep them forever",
+			Value:       24 * time.Hour,
+			Destination: &opts.EventsSyncer.EventTTL,
+		},
+		cli.IntFlag{
+			Name:        "max-events-per-namespace",
+			Usage:       "maximum number of cluster events kept in a project namespace, 0 for no limit",
+			Value:       1000,
+			Destination: &opts.EventsSyncer.MaxEventsPerNamespace,
+		},
+		cli.StringFlag{
+			Name:        "config-map",
+			Usage:       "<namespace>/<name> of the config map holding the agent event rules, empty to disable",
+			Value:       "cattle-system/cluster-agent",
+			Destination: &opts.EventsSyncer.ConfigMap,
+		},
+		cli.StringFlag{
+			Name:        "unassigned-event-policy",
+			Usage:       "what to do with events of namespaces not in a project: cluster to route them to the cluster namespace, or drop",
+			Value:       eventssyncer.UnassignedPolicyCluster,
+			Destination: &opts.EventsSyncer.UnassignedPolicy,
+		},
+		cli.Float64Flag{
+			Name:        "event-rate-limit",
+			Usage:       "events per second propagated to management, 0 for no limit",
+			Value:       50,
+			Destination: &opts.EventsSyncer.EventRateLimit,
+		},
+		cli.IntFlag{
+			Name:        "event-burst",
+			Usage:       "events propagated to management at once before the rate limit applies",
+			Value:       200,
+			Destination: &opts.EventsSyncer.EventBurst,
+		},
+		cli.Float64Flag{
+			Name:        "object-event-rate-limit",
+			Usage:       "events per second propagated to management for one object, 0 for no limit",
+			Value:       0.2,
+			Destination: &opts.EventsSyncer.ObjectEventRateLimit,
+		},
+		cli.IntFlag{
+			Name:        "object-event-burst",
+			Usage:       "events propagated to management at once for one object before the rate limit applies",
+			Value:       10,
+			Destination: &opts.EventsSyncer.ObjectEventBurst,
+		},
+		cli.IntFlag{
+			Name:        "event-spool-size",
+			Usage:       "cluster events kept while management is unavailable and created once it is back, 0 to disable",
+			Value:       5000,
+			Destination: &opts.EventsSyncer.SpoolSize,
+		},
+		cli.StringFlag{
+			Name:        "secrets-key",
+			Usage:       "<namespace>/<name> of the cluster secret holding the key encrypted project secrets are decrypted with, empty to disable",
+			Destination: &opts.Secret.KeySecret,
+		},
+		cli.StringFlag{
+			Name:  "metrics-listen-address",
+			Usage: "address to serve the agent metrics on at /debug/vars without authentication, like 127.0.0.1:9090, disabled when empty",
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
+		if address := c.String("metrics-listen-address"); address != "" {
+			go serveMetrics(address)
+		}
 		return runControllers(
 			c.String("cluster-manager-config"),
 			c.String("cluster-config"),
 			c.String("cluster-name"),
+			opts,
 		)
 	}
 
@@ -43,7 +140,14 @@ func main() {
 	app.Run(os.Args)
 }
 
-func runControllers(clusterManagerCfg string, clusterCfg string, clusterName string) error {
+func serveMetrics(address string) {
+	// expvar registers its handler on the default mux
+	if err := http.ListenAndServe(address, nil); err != nil {
+		logrus.Errorf("Failed to serve metrics on [%s]: %v", address, err)
+	}
+}
+
+func runControllers(clusterManagerCfg string, clusterCfg string, clusterName string, opts *controller.Options) error {
 	clusterManagementKubeConfig, err := clientcmd.BuildConfigFromFlags("", clusterManagerCfg)
 	if err != nil {
 		return err
@@ -60,6 +164,12 @@ func runControllers(clusterManagerCfg string, clusterCfg string, clusterName str
 	}
 
 	ctx := context.Background()
-	controller.Register(ctx, cluster)
+	if err := controller.Register(ctx, cluster, opts); err != nil {
+		return err
+	}
+	// the cluster context starts the management controllers but not the management event recorder
+	if err := cluster.Management.Start(ctx); err != nil {
+		return err
+	}
 	return cluster.StartAndWait(ctx)
 }
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer coalesces writes of an object, so it gets written at most once every MinInterval
+type Debouncer struct {
+	sync.Mutex
+	minInterval time.Duration
+	lastWrite   map[string]time.Time
+	pending     map[string]bool
+	lastPrune   time.Time
+}
+
+func NewDebouncer(minInterval time.Duration) *Debouncer {
+	return &Debouncer{
+		minInterval: minInterval,
+		lastWrite:   map[string]time.Time{},
+		pending:     map[string]bool{},
+	}
+}
+
+// Wait returns how long the write of the object has to wait. When it is the first write deferred
+// since the last one, scheduled is true and the caller has to make sure the object gets synced again
+func (d *Debouncer) Wait(key string) (after time.Duration, scheduled bool) {
+	if d == nil || d.minInterval <= 0 {
+		return 0, false
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	remaining := d.minInterval - time.Since(d.lastWrite[key])
+	if remaining <= 0 {
+		delete(d.pending, key)
+		return 0, false
+	}
+	if d.pending[key] {
+		return remaining, false
+	}
+	d.pending[key] = true
+	return remaining, true
+}
+
+// Written records the object has just been written
+func (d *Debouncer) Written(key string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.lastWrite[key] = time.Now()
+	delete(d.pending, key)
+	d.prune()
+}
+
+// Forget drops the state kept for a deleted object
+func (d *Debouncer) Forget(key string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	delete(d.lastWrite, key)
+	delete(d.pending, key)
+}
+
+// prune drops the state of the objects last written more than MinInterval ago, it no longer defers
+// anything. Objects deleted without a Forget would stay in the maps otherwise
+func (d *Debouncer) prune() {
+	if time.Since(d.lastPrune) < d.minInterval {
+		return
+	}
+	d.lastPrune = time.Now()
+	for key, lastWrite := range d.lastWrite {
+		if time.Since(lastWrite) >= d.minInterval {
+			delete(d.lastWrite, key)
+			delete(d.pending, key)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	d := NewDebouncer(time.Hour)
+
+	if after, scheduled := d.Wait("a"); after != 0 || scheduled {
+		t.Errorf("first write: got %v, %v, want no wait", after, scheduled)
+	}
+	d.Written("a")
+
+	after, scheduled := d.Wait("a")
+	if after <= 0 || after > time.Hour || !scheduled {
+		t.Errorf("write within the interval: got %v, %v, want a scheduled wait", after, scheduled)
+	}
+	if after, scheduled = d.Wait("a"); after <= 0 || scheduled {
+		t.Errorf("second write within the interval: got %v, %v, want a wait already scheduled", after, scheduled)
+	}
+	if after, scheduled = d.Wait("b"); after != 0 || scheduled {
+		t.Errorf("other key: got %v, %v, want no wait", after, scheduled)
+	}
+
+	d.Forget("a")
+	if after, scheduled = d.Wait("a"); after != 0 || scheduled {
+		t.Errorf("forgotten key: got %v, %v, want no wait", after, scheduled)
+	}
+}
+
+func TestDebouncerDisabled(t *testing.T) {
+	for _, d := range []*Debouncer{nil, NewDebouncer(0)} {
+		d.Written("a")
+		if after, scheduled := d.Wait("a"); after != 0 || scheduled {
+			t.Errorf("got %v, %v, want no wait", after, scheduled)
+		}
+		d.Forget("a")
+	}
+}
+
+func TestDebouncerInterval(t *testing.T) {
+	d := NewDebouncer(10 * time.Millisecond)
+	d.Written("a")
+	if _, scheduled := d.Wait("a"); !scheduled {
+		t.Error("write within the interval is not scheduled")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if after, scheduled := d.Wait("a"); after != 0 || scheduled {
+		t.Errorf("write after the interval: got %v, %v, want no wait", after, scheduled)
+	}
+}
+
+func TestDebouncerPrune(t *testing.T) {
+	d := NewDebouncer(10 * time.Millisecond)
+	d.Written("a")
+	d.Wait("a")
+	time.Sleep(20 * time.Millisecond)
+	d.Written("b")
+
+	if _, ok := d.lastWrite["a"]; ok {
+		t.Error("expired key is kept")
+	}
+	if d.pending["a"] {
+		t.Error("expired pending key is kept")
+	}
+	if _, ok := d.lastWrite["b"]; !ok {
+		t.Error("key just written is pruned")
+	}
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewInformer returns an informer of the resource served by the REST client in every namespace,
+// indexed by namespace. It is for the types the cluster context has no controllers for, and has to be
+// run by the caller
+func NewInformer(client cache.Getter, resource string, objType runtime.Object) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		cache.NewListWatchFromClient(client, resource, metav1.NamespaceAll, fields.Everything()),
+		objType,
+		0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/rancher/types/config"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// RecorderComponent is the source component of the events recorded by the agent
+const RecorderComponent = "cluster-agent"
+
+// Recorders record the actions and failures of the agent as events on the objects it acts on.
+// Cluster is for downstream objects, Management for the management objects of the cluster and
+// is the recorder of the management context, its events are written once that context is started
+type Recorders struct {
+	Cluster    record.EventRecorder
+	Management record.EventRecorder
+}
+
+// NewRecorders returns the agent recorders, the cluster one stops writing events once the context is done
+func NewRecorders(ctx context.Context, cluster *config.ClusterContext) *Recorders {
+	return &Recorders{
+		Cluster:    newClusterRecorder(ctx, cluster.K8sClient, cluster.ClusterName),
+		Management: cluster.Management.Events,
+	}
+}
+
+func newClusterRecorder(ctx context.Context, client kubernetes.Interface, clusterName string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	logger := broadcaster.StartLogging(logrus.Debugf)
+	watcher := broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+	go func() {
+		<-ctx.Done()
+		logger.Stop()
+		watcher.Stop()
+	}()
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: RecorderComponent,
+		Host:      clusterName,
+	})
+}